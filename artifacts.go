@@ -0,0 +1,255 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ArtifactStoreNone       = ""
+	ArtifactStoreFilesystem = "filesystem"
+	ArtifactStoreS3         = "s3"
+
+	ArtifactSummaryName = "summary.json"
+)
+
+// S3RequestTimeout limits each request to the S3 compatible artifact store including the upload, so an
+// unresponsive store can't block the test run forever
+const S3RequestTimeout = 5 * time.Minute
+
+// ArtifactStore persists the artifacts of test runs
+type ArtifactStore interface {
+	// Put stores the content of the given size under the key
+	Put(key string, content io.Reader, size int64) error
+	// Location returns a link to the given key
+	Location(key string) string
+}
+
+// NewArtifactStore creates the artifact store configured by the environment, nil if none is configured
+func NewArtifactStore(env envConfig) (ArtifactStore, error) {
+	switch env.ArtifactStore {
+	case ArtifactStoreNone:
+		return nil, nil
+	case ArtifactStoreFilesystem:
+		if env.ArtifactStoreDir == "" {
+			return nil, fmt.Errorf("ARTIFACT_STORE_DIR is required for the filesystem artifact store")
+		}
+		return &FilesystemArtifactStore{BaseDir: env.ArtifactStoreDir}, nil
+	case ArtifactStoreS3:
+		if env.S3Endpoint == "" || env.S3Bucket == "" {
+			return nil, fmt.Errorf("S3_ENDPOINT and S3_BUCKET are required for the s3 artifact store")
+		}
+		return &S3ArtifactStore{
+			Endpoint:        strings.TrimSuffix(env.S3Endpoint, "/"),
+			Bucket:          env.S3Bucket,
+			Region:          env.S3Region,
+			AccessKeyID:     env.S3AccessKeyID,
+			SecretAccessKey: env.S3SecretAccessKey,
+			Client:          &http.Client{Timeout: S3RequestTimeout},
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown artifact store %s", env.ArtifactStore)
+}
+
+// storeRunArtifacts persists the results directory and the JSON summary of a test run below the prefix
+// and returns the location of the stored artifacts
+func storeRunArtifacts(store ArtifactStore, prefix string, resultsDir string, summary interface{}) (string, error) {
+	content, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err = store.Put(path.Join(prefix, ArtifactSummaryName), strings.NewReader(string(content)), int64(len(content))); err != nil {
+		return "", err
+	}
+
+	err = walkFiles(resultsDir, func(file, relativePath string) error {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		return store.Put(path.Join(prefix, ResultsDirname, relativePath), f, info.Size())
+	})
+	if err != nil {
+		return "", err
+	}
+
+	log.Infof("Stored artifacts of the test run at %s", store.Location(prefix))
+	return store.Location(prefix), nil
+}
+
+// FilesystemArtifactStore stores artifacts in a local directory, e.g. a mounted volume
+type FilesystemArtifactStore struct {
+	BaseDir string
+}
+
+// Put writes the content into a file below the base directory
+func (s *FilesystemArtifactStore) Put(key string, content io.Reader, size int64) error {
+	target, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return err
+	}
+	f, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, content)
+	return err
+}
+
+// Location returns the file URL of the key
+func (s *FilesystemArtifactStore) Location(key string) string {
+	target, err := s.path(key)
+	if err != nil {
+		return ""
+	}
+	if absolute, err := filepath.Abs(target); err == nil {
+		target = absolute
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(target)}).String()
+}
+
+func (s *FilesystemArtifactStore) path(key string) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if cleaned != "/"+strings.TrimPrefix(key, "/") {
+		return "", fmt.Errorf("invalid artifact key %s", key)
+	}
+	return filepath.Join(s.BaseDir, filepath.FromSlash(cleaned)), nil
+}
+
+// S3ArtifactStore stores artifacts in an S3 compatible object storage (e.g. AWS S3 or MinIO) using path style requests
+type S3ArtifactStore struct {
+	Endpoint        string
+	Bucket          string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	Client          *http.Client
+}
+
+// Put uploads the content as object with the key as name
+func (s *S3ArtifactStore) Put(key string, content io.Reader, size int64) error {
+	req, err := http.NewRequest(http.MethodPut, s.Location(key), ioutil.NopCloser(content))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	// the payload is streamed, thus it's not part of the signature
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	signS3Request(req, s.AccessKeyID, s.SecretAccessKey, s.Region, time.Now())
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("error storing %s in bucket %s: %s %s", key, s.Bucket, resp.Status, string(body))
+	}
+	return nil
+}
+
+// Location returns the URL of the object
+func (s *S3ArtifactStore) Location(key string) string {
+	return s.Endpoint + "/" + s.Bucket + encodeS3Path(key)
+}
+
+// signS3Request adds an AWS signature version 4 to the request, signing the host and all headers set on the request
+func signS3Request(req *http.Request, accessKeyID, secretAccessKey, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		encodeS3Path(strings.TrimPrefix(req.URL.Path, "/")),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, "s3", "aws4_request"}, "/")
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(canonicalRequestHash[:]),
+	}, "\n")
+
+	signature := hex.EncodeToString(hmacSHA256(s3SigningKey(secretAccessKey, date, region, "s3"), stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKeyID, scope, signedHeaders, signature))
+}
+
+// s3SigningKey derives the signing key of AWS signature version 4
+func s3SigningKey(secretAccessKey, date, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// encodeS3Path URI encodes all characters of the slash separated path except the unreserved ones
+func encodeS3Path(p string) string {
+	var encoded strings.Builder
+	encoded.WriteString("/")
+	for _, b := range []byte(p) {
+		switch {
+		case b >= 'A' && b <= 'Z', b >= 'a' && b <= 'z', b >= '0' && b <= '9', b == '-', b == '.', b == '_', b == '~', b == '/':
+			encoded.WriteByte(b)
+		default:
+			encoded.WriteString(fmt.Sprintf("%%%02X", b))
+		}
+	}
+	return encoded.String()
+}
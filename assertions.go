@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
+)
+
+const (
+	AssertionsFilename = "assertions.json"
+
+	SeverityFail    = "fail"
+	SeverityWarning = "warning"
+)
+
+// AssertionResult outcome of a single assertion
+type AssertionResult struct {
+	Message  string `json:"message"`
+	Passed   bool   `json:"passed"`
+	Actual   string `json:"actual,omitempty"`
+	Severity string `json:"severity"`
+}
+
+// gatlingAssertionReport structure of the js/assertions.json file written by Gatling
+type gatlingAssertionReport struct {
+	Simulation string `json:"simulation"`
+	Assertions []struct {
+		Path        string          `json:"path"`
+		Target      string          `json:"target"`
+		Condition   string          `json:"condition"`
+		Result      bool            `json:"result"`
+		Message     string          `json:"message"`
+		ActualValue json.RawMessage `json:"actualValue"`
+	} `json:"assertions"`
+}
+
+// loadGatlingAssertions reads the assertion results of all runs within the results folder
+func loadGatlingAssertions(resultsDir string) ([]*AssertionResult, error) {
+	var files []string
+	err := filepath.Walk(resultsDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && info.Name() == AssertionsFilename {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var assertions []*AssertionResult
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		report := &gatlingAssertionReport{}
+		if err = json.Unmarshal(content, report); err != nil {
+			return nil, fmt.Errorf("error parsing %s: %s", file, err.Error())
+		}
+		for _, assertion := range report.Assertions {
+			message := assertion.Message
+			if message == "" {
+				message = fmt.Sprintf("%s: %s %s", assertion.Path, assertion.Target, assertion.Condition)
+			}
+			assertions = append(assertions, &AssertionResult{
+				Message:  message,
+				Passed:   assertion.Result,
+				Actual:   strings.Trim(string(assertion.ActualValue), "[]"),
+				Severity: SeverityFail,
+			})
+		}
+	}
+	return assertions, nil
+}
+
+// validateAssertions checks the configured assertions before the test is run
+func validateAssertions(assertions []*Assertion) error {
+	for _, assertion := range assertions {
+		if assertion.Min == nil && assertion.Max == nil {
+			return fmt.Errorf("assertion for %s requires a min or max value", assertion.Metric)
+		}
+		if assertion.Severity != "" && assertion.Severity != SeverityFail && assertion.Severity != SeverityWarning {
+			return fmt.Errorf("unknown severity %s for assertion on %s", assertion.Severity, assertion.Metric)
+		}
+		if _, err := (&RequestStatistics{}).metricValue(assertion.Metric); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// evaluateAssertions checks the configured assertions against the parsed results
+func evaluateAssertions(assertions []*Assertion, results *SimulationResults) ([]*AssertionResult, error) {
+	if err := validateAssertions(assertions); err != nil {
+		return nil, err
+	}
+
+	var evaluated []*AssertionResult
+	for _, assertion := range assertions {
+		severity := assertion.Severity
+		if severity == "" {
+			severity = SeverityFail
+		}
+
+		result := &AssertionResult{
+			Message:  assertion.describe(),
+			Severity: severity,
+		}
+		evaluated = append(evaluated, result)
+
+		if results == nil {
+			result.Actual = "no results"
+			continue
+		}
+		stats := results.requestStatistics(assertion.Request)
+		if stats == nil {
+			result.Actual = "request not found"
+			continue
+		}
+
+		value, _ := stats.metricValue(assertion.Metric)
+		result.Actual = strconv.FormatFloat(value, 'f', -1, 64)
+		result.Passed = (assertion.Min == nil || value >= *assertion.Min) && (assertion.Max == nil || value <= *assertion.Max)
+	}
+	return evaluated, nil
+}
+
+// describe returns a human readable form of the assertion
+func (a *Assertion) describe() string {
+	scope := GlobalRequestName
+	if a.Request != "" {
+		scope = a.Request
+	}
+	var bounds []string
+	if a.Min != nil {
+		bounds = append(bounds, fmt.Sprintf("is at least %s", strconv.FormatFloat(*a.Min, 'f', -1, 64)))
+	}
+	if a.Max != nil {
+		bounds = append(bounds, fmt.Sprintf("is at most %s", strconv.FormatFloat(*a.Max, 'f', -1, 64)))
+	}
+	return fmt.Sprintf("%s: %s %s", scope, a.Metric, strings.Join(bounds, " and "))
+}
+
+// assertionsVerdict maps the failed assertions to a Keptn result
+func assertionsVerdict(assertions []*AssertionResult) keptnv2.ResultType {
+	result := keptnv2.ResultPass
+	for _, assertion := range failedAssertions(assertions) {
+		if assertion.Severity == SeverityWarning {
+			result = keptnv2.ResultWarning
+		} else {
+			return keptnv2.ResultFailed
+		}
+	}
+	return result
+}
+
+// failedAssertions filters the assertions which did not pass
+func failedAssertions(assertions []*AssertionResult) []*AssertionResult {
+	var failed []*AssertionResult
+	for _, assertion := range assertions {
+		if !assertion.Passed {
+			failed = append(failed, assertion)
+		}
+	}
+	return failed
+}
+
+// describeAssertions joins the assertion messages including the actual values
+func describeAssertions(assertions []*AssertionResult) string {
+	messages := make([]string, 0, len(assertions))
+	for _, assertion := range assertions {
+		if assertion.Actual != "" {
+			messages = append(messages, fmt.Sprintf("%s (actual: %s)", assertion.Message, assertion.Actual))
+		} else {
+			messages = append(messages, assertion.Message)
+		}
+	}
+	return strings.Join(messages, "; ")
+}
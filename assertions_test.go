@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
+)
+
+func loadTestResults(t *testing.T) *SimulationResults {
+	f, err := os.Open(path.Join("test-data", "results", SimulationLogName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	simLog, err := parseSimulationLog(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return computeResults(simLog)
+}
+
+func TestEvaluateAssertions(t *testing.T) {
+	results := loadTestResults(t)
+	value := func(v float64) *float64 {
+		return &v
+	}
+
+	type test struct {
+		name           string
+		assertions     []*Assertion
+		expectedResult keptnv2.ResultType
+	}
+
+	tests := []test{
+		{
+			"Global error rate below limit",
+			[]*Assertion{{Metric: "error_rate", Max: value(25)}},
+			keptnv2.ResultPass,
+		},
+		{
+			"Global error rate above limit",
+			[]*Assertion{{Metric: "error_rate", Max: value(20)}},
+			keptnv2.ResultFailed,
+		},
+		{
+			"Minimum throughput as warning",
+			[]*Assertion{{Metric: "throughput", Min: value(5), Severity: SeverityWarning}},
+			keptnv2.ResultWarning,
+		},
+		{
+			"Request specific criteria",
+			[]*Assertion{{Metric: "response_time_max", Request: "request_1", Max: value(200)}},
+			keptnv2.ResultPass,
+		},
+		{
+			"Unknown request",
+			[]*Assertion{{Metric: "response_time_max", Request: "request_3", Max: value(200)}},
+			keptnv2.ResultFailed,
+		},
+		{
+			"Failure wins over warning",
+			[]*Assertion{
+				{Metric: "response_time_p95", Max: value(300), Severity: SeverityWarning},
+				{Metric: "ko", Max: value(0)},
+			},
+			keptnv2.ResultFailed,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			evaluated, err := evaluateAssertions(testCase.assertions, results)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			if result := assertionsVerdict(evaluated); result != testCase.expectedResult {
+				t.Errorf("Expected result %s got %s", testCase.expectedResult, result)
+			}
+		})
+	}
+
+	t.Run("Invalid metric", func(t *testing.T) {
+		_, err := evaluateAssertions([]*Assertion{{Metric: "response_time_p42", Max: value(1)}}, results)
+		if err == nil {
+			t.Errorf("Expected an error")
+		}
+	})
+}
+
+func TestValidateAssertions(t *testing.T) {
+	value := func(v float64) *float64 {
+		return &v
+	}
+
+	tests := []struct {
+		name       string
+		assertions []*Assertion
+		valid      bool
+	}{
+		{"Valid", []*Assertion{{Metric: "response_time_p95", Max: value(300), Severity: SeverityWarning}}, true},
+		{"Unknown metric", []*Assertion{{Metric: "p95", Max: value(300)}}, false},
+		{"Missing bounds", []*Assertion{{Metric: "error_rate"}}, false},
+		{"Unknown severity", []*Assertion{{Metric: "error_rate", Max: value(1), Severity: "info"}}, false},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			if err := validateAssertions(testCase.assertions); (err == nil) != testCase.valid {
+				t.Errorf("Expected valid=%v got %v", testCase.valid, err)
+			}
+		})
+	}
+}
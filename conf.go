@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// CurrentSpecVersion is assumed for files without spec_version
+const CurrentSpecVersion = "0.1.0"
+
+// ConfValidationError lists the problems of an invalid gatling.conf.yaml
+type ConfValidationError struct {
+	Layer    string
+	Problems []string
+}
+
+func (e *ConfValidationError) Error() string {
+	return fmt.Sprintf("invalid %s of %s: %s", ConfFilename, e.Layer, strings.Join(e.Problems, "; "))
+}
+
+// ConfLayer gatling.conf.yaml of a single level, i.e. project, stage or service
+type ConfLayer struct {
+	Name    string
+	Content []byte
+}
+
+// confSchema describes the expected structure of a YAML node
+type confSchema struct {
+	kind yaml.Kind
+	// keys of a mapping
+	fields map[string]*confSchema
+	// deprecated keys of a mapping, which are renamed to the key they're mapped to
+	deprecated map[string]string
+	// schema of the values of a mapping with arbitrary keys
+	entries *confSchema
+	// schema of the items of a sequence
+	items *confSchema
+	// allowed values of a scalar, any value if empty
+	values []string
+}
+
+func scalar(values ...string) *confSchema {
+	return &confSchema{kind: yaml.ScalarNode, values: values}
+}
+
+func sequence(items *confSchema) *confSchema {
+	return &confSchema{kind: yaml.SequenceNode, items: items}
+}
+
+var assertionSchemaV010 = &confSchema{
+	kind: yaml.MappingNode,
+	fields: map[string]*confSchema{
+		"metric":   scalar(Metrics...),
+		"request":  scalar(),
+		"max":      scalar(),
+		"min":      scalar(),
+		"severity": scalar(SeverityFail, SeverityWarning),
+	},
+}
+
+var workloadSchemaV010 = &confSchema{
+	kind: yaml.MappingNode,
+	fields: map[string]*confSchema{
+		"teststrategy":       scalar(),
+		"stage":              scalar(),
+		"deploymentstrategy": scalar(),
+		"deploymentNames":    sequence(scalar()),
+		"simulation":         scalar(),
+		"simulations":        sequence(scalar()),
+		"execution":          scalar(ExecutionSequential, ExecutionParallel),
+		"stopOnFailure":      scalar(),
+		"timeout":            scalar(),
+		"properties":         {kind: yaml.MappingNode, entries: scalar()},
+		"jvmOptions":         sequence(scalar()),
+		"deploymentURIs":     scalar(DeploymentURIsLocal, DeploymentURIsPublic),
+		"runPerURI":          scalar(),
+		"assertions":         sequence(assertionSchemaV010),
+	},
+	deprecated: map[string]string{
+		"testStrategy":       "teststrategy",
+		"deploymentStrategy": "deploymentstrategy",
+	},
+}
+
+// confSchemas the schema of gatling.conf.yaml per spec_version
+var confSchemas = map[string]*confSchema{
+	"0.1.0": {
+		kind: yaml.MappingNode,
+		fields: map[string]*confSchema{
+			"spec_version":     scalar(),
+			"missingResources": scalar(MissingResourcesPass, MissingResourcesWarning, MissingResourcesFail),
+			"reports": {
+				kind: yaml.MappingNode,
+				fields: map[string]*confSchema{
+					"upload": scalar(ReportUploadNone, ReportUploadZip, ReportUploadFiles),
+				},
+			},
+			"workloads": sequence(workloadSchemaV010),
+		},
+		deprecated: map[string]string{
+			"specVersion": "spec_version",
+		},
+	},
+}
+
+// validateConfDocument checks the document against the schema of its spec_version and renames deprecated keys.
+// The problems are reported with line and column, the warnings name the deprecated keys.
+func validateConfDocument(document *yaml.Node) (problems []string, warnings []string) {
+	if document.Kind == 0 {
+		// empty file
+		return nil, nil
+	}
+	root := document
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return []string{fmt.Sprintf("line %d, column %d: expected a mapping", root.Line, root.Column)}, nil
+	}
+
+	specVersion := CurrentSpecVersion
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if key := root.Content[i].Value; key == "spec_version" || key == "specVersion" {
+			specVersion = root.Content[i+1].Value
+		}
+	}
+	schema, ok := confSchemas[specVersion]
+	if !ok {
+		return []string{fmt.Sprintf("unsupported spec_version %s, supported versions are %s", specVersion, strings.Join(supportedSpecVersions(), ", "))}, nil
+	}
+
+	validator := &confValidator{}
+	validator.validate(root, schema, "")
+	return validator.problems, validator.warnings
+}
+
+func supportedSpecVersions() []string {
+	var versions []string
+	for version := range confSchemas {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+type confValidator struct {
+	problems []string
+	warnings []string
+}
+
+func (v *confValidator) problem(node *yaml.Node, format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf("line %d, column %d: %s", node.Line, node.Column, fmt.Sprintf(format, args...)))
+}
+
+func (v *confValidator) validate(node *yaml.Node, schema *confSchema, path string) {
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return
+	}
+	if node.Kind != schema.kind {
+		v.problem(node, "expected %s for %s", kindName(schema.kind), describePath(path))
+		return
+	}
+
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if len(schema.values) > 0 && !containsString(schema.values, node.Value) {
+			v.problem(node, "invalid value %q for %s, expected one of %s", node.Value, describePath(path), strings.Join(schema.values, ", "))
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			v.validate(item, schema.items, fmt.Sprintf("%s[%d]", path, i))
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			keyPath := strings.TrimPrefix(path+"."+key.Value, ".")
+			if schema.entries != nil {
+				v.validate(value, schema.entries, keyPath)
+				continue
+			}
+			if replacement, ok := schema.deprecated[key.Value]; ok {
+				v.warnings = append(v.warnings, fmt.Sprintf("line %d, column %d: %s is deprecated, use %s instead", key.Line, key.Column, keyPath, replacement))
+				key.Value = replacement
+			}
+			field, ok := schema.fields[key.Value]
+			if !ok {
+				v.problem(key, "unknown key %s", keyPath)
+				continue
+			}
+			v.validate(value, field, keyPath)
+		}
+	}
+}
+
+func describePath(path string) string {
+	if path == "" {
+		return "the document"
+	}
+	return path
+}
+
+func kindName(kind yaml.Kind) string {
+	switch kind {
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.SequenceNode:
+		return "a list"
+	}
+	return "a value"
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// parseConfLayer validates the layer and decodes it strictly, deprecated keys are renamed
+func parseConfLayer(layer *ConfLayer) (map[string]interface{}, error) {
+	document := &yaml.Node{}
+	if err := yaml.Unmarshal(layer.Content, document); err != nil {
+		return nil, &ConfValidationError{Layer: layer.Name, Problems: []string{err.Error()}}
+	}
+
+	problems, warnings := validateConfDocument(document)
+	for _, warning := range warnings {
+		log.Warnf("%s of %s: %s", ConfFilename, layer.Name, warning)
+	}
+	if len(problems) > 0 {
+		return nil, &ConfValidationError{Layer: layer.Name, Problems: problems}
+	}
+
+	// decoding into the types catches invalid scalars, e.g. a string as max of an assertion
+	if err := document.Decode(&GatlingConf{}); err != nil {
+		return nil, &ConfValidationError{Layer: layer.Name, Problems: []string{err.Error()}}
+	}
+
+	values := map[string]interface{}{}
+	if err := document.Decode(&values); err != nil {
+		return nil, &ConfValidationError{Layer: layer.Name, Problems: []string{err.Error()}}
+	}
+	return values, nil
+}
+
+// mergeGatlingConf deep merges the layers, later layers override earlier ones. Maps are merged recursively,
+// workloads are merged by their teststrategy and selectors and all other values are replaced.
+func mergeGatlingConf(layers []*ConfLayer) (*GatlingConf, error) {
+	var merged map[string]interface{}
+	for _, layer := range layers {
+		document, err := parseConfLayer(layer)
+		if err != nil {
+			return nil, err
+		}
+		merged = mergeConfMaps(merged, document)
+	}
+	if merged == nil {
+		return nil, nil
+	}
+
+	content, err := yaml.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	return parseGatlingConf(content)
+}
+
+func mergeConfMaps(base, override map[string]interface{}) map[string]interface{} {
+	merged := map[string]interface{}{}
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range override {
+		merged[key] = mergeConfValues(key, merged[key], value)
+	}
+	return merged
+}
+
+func mergeConfValues(key string, base, override interface{}) interface{} {
+	switch overrideValue := override.(type) {
+	case map[string]interface{}:
+		if baseValue, ok := base.(map[string]interface{}); ok {
+			return mergeConfMaps(baseValue, overrideValue)
+		}
+	case []interface{}:
+		if baseValue, ok := base.([]interface{}); ok && key == "workloads" {
+			return mergeWorkloads(baseValue, overrideValue)
+		}
+	}
+	return override
+}
+
+// mergeWorkloads merges workloads with the same identity and appends the others
+func mergeWorkloads(base, override []interface{}) []interface{} {
+	merged := append([]interface{}{}, base...)
+	for _, workload := range override {
+		overrideWorkload, ok := workload.(map[string]interface{})
+		if !ok {
+			merged = append(merged, workload)
+			continue
+		}
+
+		replaced := false
+		for i, existing := range merged {
+			existingWorkload, ok := existing.(map[string]interface{})
+			if ok && workloadIdentity(existingWorkload) == workloadIdentity(overrideWorkload) {
+				merged[i] = mergeConfMaps(existingWorkload, overrideWorkload)
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, overrideWorkload)
+		}
+	}
+	return merged
+}
+
+// workloadIdentity identifies a workload by its teststrategy and selectors
+func workloadIdentity(workload map[string]interface{}) string {
+	identity := ""
+	for _, key := range []string{"teststrategy", "stage", "deploymentstrategy", "deploymentNames"} {
+		if value, ok := workload[key]; ok && value != nil {
+			identity += fmt.Sprintf("%s=%v;", key, value)
+		}
+	}
+	return identity
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	b64 "encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	keptnapimodels "github.com/keptn/go-utils/pkg/api/models"
+	"gopkg.in/yaml.v3"
+)
+
+const projectConf = `
+spec_version: '0.1.0'
+reports:
+  upload: zip
+workloads:
+  - teststrategy: performance
+    simulation: BasicSimulation
+    timeout: 30m
+    properties:
+      users: "10"
+      duration: "60"
+    assertions:
+      - metric: error_rate
+        max: 1
+  - teststrategy: functional
+    simulation: SmokeSimulation
+`
+
+const stageConf = `
+workloads:
+  - teststrategy: performance
+    properties:
+      users: "50"
+`
+
+const serviceConf = `
+reports:
+  upload: none
+workloads:
+  - teststrategy: performance
+    assertions:
+      - metric: response_time_p95
+        max: 500
+  - teststrategy: performance
+    deploymentNames:
+      - canary
+    simulation: CanarySimulation
+`
+
+func TestMergeGatlingConf(t *testing.T) {
+	conf, err := mergeGatlingConf([]*ConfLayer{
+		{Name: "project", Content: []byte(projectConf)},
+		{Name: "stage", Content: []byte(stageConf)},
+		{Name: "service", Content: []byte(serviceConf)},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if conf.SpecVersion != "0.1.0" {
+		t.Errorf("Expected the spec version of the project got %s", conf.SpecVersion)
+	}
+	if conf.Reports == nil || conf.Reports.Upload != ReportUploadNone {
+		t.Errorf("Expected the report upload to be overridden by the service")
+	}
+	if len(conf.Workloads) != 3 {
+		t.Fatalf("Expected 3 workloads got %d", len(conf.Workloads))
+	}
+
+	performance := conf.Workloads[0]
+	if performance.Simulation != "BasicSimulation" || performance.Timeout != "30m" {
+		t.Errorf("Expected the simulation and timeout of the project got %s/%s", performance.Simulation, performance.Timeout)
+	}
+	if performance.Properties["users"] != "50" || performance.Properties["duration"] != "60" {
+		t.Errorf("Expected the properties to be merged got %v", performance.Properties)
+	}
+	if len(performance.Assertions) != 1 || performance.Assertions[0].Metric != "response_time_p95" {
+		t.Errorf("Expected the assertions to be replaced by the service")
+	}
+	if conf.Workloads[1].Simulation != "SmokeSimulation" || conf.Workloads[2].Simulation != "CanarySimulation" {
+		t.Errorf("Expected the other workloads to be kept and appended")
+	}
+}
+
+func TestMergeGatlingConfInvalid(t *testing.T) {
+	_, err := mergeGatlingConf([]*ConfLayer{
+		{Name: "project", Content: []byte(projectConf)},
+		{Name: "stage dev", Content: []byte("workloads: [")},
+	})
+	if err == nil || !strings.Contains(err.Error(), "stage dev") {
+		t.Errorf("Expected an error naming the layer got %v", err)
+	}
+}
+
+func TestGetGatlingConfLayers(t *testing.T) {
+	layers := map[string]string{
+		"/v1/project/pod-tato-head/resource/":                                      projectConf,
+		"/v1/project/pod-tato-head/stage/hardening/resource/":                      stageConf,
+		"/v1/project/pod-tato-head/stage/hardening/service/helloservice/resource/": serviceConf,
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+		for prefix, content := range layers {
+			if r.URL.Path == prefix+"gatling/gatling.conf.yaml" {
+				uri := "gatling/gatling.conf.yaml"
+				marshal, _ := json.Marshal(&keptnapimodels.Resource{
+					ResourceURI:     &uri,
+					ResourceContent: b64.StdEncoding.EncodeToString([]byte(content)),
+				})
+				_, _ = w.Write(marshal)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"code": 404, "message": ""}`))
+	}))
+	defer ts.Close()
+
+	myKeptn, _, err := initializeTestObjects(ts.URL, "test-events/test.triggered.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := getGatlingConf(myKeptn, "pod-tato-head", "hardening", "helloservice")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if conf == nil || len(conf.Workloads) != 3 || conf.Workloads[0].Properties["users"] != "50" {
+		t.Errorf("Expected the merged configuration of all levels got %+v", conf)
+	}
+
+	delete(layers, "/v1/project/pod-tato-head/stage/hardening/service/helloservice/resource/")
+	delete(layers, "/v1/project/pod-tato-head/stage/hardening/resource/")
+	conf, err = getGatlingConf(myKeptn, "pod-tato-head", "hardening", "helloservice")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if conf == nil || len(conf.Workloads) != 2 || conf.Workloads[0].Properties["users"] != "10" {
+		t.Errorf("Expected the configuration of the project got %+v", conf)
+	}
+}
+
+func TestValidateConfDocument(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		problems []string
+		warnings []string
+	}{
+		{"empty", ``, nil, nil},
+		{"valid", projectConf, nil, nil},
+		{
+			"unknown key",
+			"workloads:\n  - teststrategy: performance\n    simualtion: BasicSimulation\n",
+			[]string{"line 3, column 5: unknown key workloads[0].simualtion"},
+			nil,
+		},
+		{
+			"wrong kind",
+			"workloads:\n  teststrategy: performance\n",
+			[]string{"line 2, column 3: expected a list for workloads"},
+			nil,
+		},
+		{
+			"invalid value",
+			"reports:\n  upload: always\n",
+			[]string{`line 2, column 11: invalid value "always" for reports.upload, expected one of none, zip, files`},
+			nil,
+		},
+		{
+			"unknown metric",
+			"workloads:\n  - assertions:\n      - metric: p95\n        max: 300\n",
+			[]string{`line 3, column 17: invalid value "p95" for workloads[0].assertions[0].metric, expected one of ` + strings.Join(Metrics, ", ")},
+			nil,
+		},
+		{
+			"unsupported spec version",
+			"spec_version: '9.9.9'\n",
+			[]string{"unsupported spec_version 9.9.9, supported versions are 0.1.0"},
+			nil,
+		},
+		{
+			"not a mapping",
+			"- workloads\n",
+			[]string{"line 1, column 1: expected a mapping"},
+			nil,
+		},
+		{
+			"deprecated key",
+			"workloads:\n  - testStrategy: performance\n",
+			nil,
+			[]string{"line 2, column 5: workloads[0].testStrategy is deprecated, use teststrategy instead"},
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			document := &yaml.Node{}
+			if err := yaml.Unmarshal([]byte(testCase.input), document); err != nil {
+				t.Fatal(err)
+			}
+			problems, warnings := validateConfDocument(document)
+			if strings.Join(problems, "\n") != strings.Join(testCase.problems, "\n") {
+				t.Errorf("Expected problems %v got %v", testCase.problems, problems)
+			}
+			if strings.Join(warnings, "\n") != strings.Join(testCase.warnings, "\n") {
+				t.Errorf("Expected warnings %v got %v", testCase.warnings, warnings)
+			}
+		})
+	}
+}
+
+func TestParseConfLayerDeprecatedKeys(t *testing.T) {
+	conf, err := mergeGatlingConf([]*ConfLayer{
+		{Name: "project", Content: []byte("specVersion: '0.1.0'\nworkloads:\n  - testStrategy: performance\n    simulation: BasicSimulation\n")},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if conf.SpecVersion != "0.1.0" || len(conf.Workloads) != 1 || conf.Workloads[0].TestStrategy != "performance" {
+		t.Errorf("Expected the deprecated keys to be renamed got %+v", conf)
+	}
+}
+
+func TestParseConfLayerInvalidType(t *testing.T) {
+	_, err := mergeGatlingConf([]*ConfLayer{
+		{Name: "project", Content: []byte("workloads:\n  - teststrategy: performance\n    stopOnFailure: maybe\n")},
+	})
+	var validationErr *ConfValidationError
+	if !errors.As(err, &validationErr) || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("Expected a validation error with the line got %v", err)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	DedupStoreNone   = "none"
+	DedupStoreMemory = "memory"
+	DedupStoreFile   = "file"
+)
+
+// DedupEntry a handled test.triggered event, the outcome is nil while the test is still running
+type DedupEntry struct {
+	EventID string                        `json:"eventID"`
+	Claimed time.Time                     `json:"claimed"`
+	Outcome *GatlingTestFinishedEventData `json:"outcome,omitempty"`
+}
+
+// DedupStore remembers handled test.triggered events to detect redelivered ones
+type DedupStore interface {
+	// Claim registers the event unless it's already known, in which case the existing entry is returned
+	Claim(eventID string) (existing *DedupEntry, err error)
+	// Complete records the test.finished event sent for the event
+	Complete(eventID string, outcome *GatlingTestFinishedEventData) error
+	// Release forgets the event, so it's handled again when it's redelivered
+	Release(eventID string) error
+}
+
+// NewDedupStore creates the deduplication store configured by the environment, nil if disabled
+func NewDedupStore(env envConfig) (DedupStore, error) {
+	switch env.DedupStore {
+	case DedupStoreNone:
+		return nil, nil
+	case DedupStoreMemory:
+		return NewMemoryDedupStore(env.DedupTTL), nil
+	case DedupStoreFile:
+		if env.DedupStoreFile == "" {
+			return nil, fmt.Errorf("DEDUP_STORE_FILE is required for the file deduplication store")
+		}
+		return NewFileDedupStore(env.DedupStoreFile, env.DedupTTL)
+	}
+	return nil, fmt.Errorf("unknown deduplication store %s", env.DedupStore)
+}
+
+// dedupEntries entries of a store by event ID, the caller must synchronize the access
+type dedupEntries map[string]*DedupEntry
+
+// claim returns a copy of the entry of the event or adds a new one, expired entries are dropped before
+func (entries dedupEntries) claim(eventID string, ttl time.Duration, now time.Time) *DedupEntry {
+	for id, entry := range entries {
+		if now.Sub(entry.Claimed) >= ttl {
+			delete(entries, id)
+		}
+	}
+	if existing, ok := entries[eventID]; ok {
+		copied := *existing
+		return &copied
+	}
+	entries[eventID] = &DedupEntry{EventID: eventID, Claimed: now}
+	return nil
+}
+
+// complete records the outcome of the event, it's added again in case it expired in the meantime
+func (entries dedupEntries) complete(eventID string, outcome *GatlingTestFinishedEventData, now time.Time) {
+	entry, ok := entries[eventID]
+	if !ok {
+		entry = &DedupEntry{EventID: eventID, Claimed: now}
+		entries[eventID] = entry
+	}
+	entry.Outcome = outcome
+}
+
+// MemoryDedupStore keeps the handled events in memory, thus they're lost on restart
+type MemoryDedupStore struct {
+	mutex   sync.Mutex
+	ttl     time.Duration
+	entries dedupEntries
+	now     func() time.Time
+}
+
+// NewMemoryDedupStore creates a store keeping the events for ttl
+func NewMemoryDedupStore(ttl time.Duration) *MemoryDedupStore {
+	return &MemoryDedupStore{ttl: ttl, entries: dedupEntries{}, now: time.Now}
+}
+
+// Claim registers the event unless it's already known
+func (s *MemoryDedupStore) Claim(eventID string) (*DedupEntry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.entries.claim(eventID, s.ttl, s.now()), nil
+}
+
+// Complete records the test.finished event sent for the event
+func (s *MemoryDedupStore) Complete(eventID string, outcome *GatlingTestFinishedEventData) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.entries.complete(eventID, outcome, s.now())
+	return nil
+}
+
+// Release forgets the event
+func (s *MemoryDedupStore) Release(eventID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.entries, eventID)
+	return nil
+}
+
+// FileDedupStore keeps the handled events in a JSON file, e.g. on a mounted volume, so they survive restarts
+type FileDedupStore struct {
+	mutex sync.Mutex
+	path  string
+	ttl   time.Duration
+	now   func() time.Time
+}
+
+// NewFileDedupStore creates a store keeping the events for ttl in the file. Events without outcome are dropped,
+// the tests were interrupted by the restart and are executed again when the event is redelivered.
+func NewFileDedupStore(path string, ttl time.Duration) (*FileDedupStore, error) {
+	s := &FileDedupStore{path: path, ttl: ttl, now: time.Now}
+	entries, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	for id, entry := range entries {
+		if entry.Outcome == nil {
+			delete(entries, id)
+		}
+	}
+	return s, s.save(entries)
+}
+
+// Claim registers the event unless it's already known
+func (s *FileDedupStore) Claim(eventID string) (*DedupEntry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entries, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	existing := entries.claim(eventID, s.ttl, s.now())
+	if existing != nil {
+		return existing, nil
+	}
+	return nil, s.save(entries)
+}
+
+// Complete records the test.finished event sent for the event
+func (s *FileDedupStore) Complete(eventID string, outcome *GatlingTestFinishedEventData) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entries, err := s.load()
+	if err != nil {
+		return err
+	}
+	entries.complete(eventID, outcome, s.now())
+	return s.save(entries)
+}
+
+// Release forgets the event
+func (s *FileDedupStore) Release(eventID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entries, err := s.load()
+	if err != nil {
+		return err
+	}
+	delete(entries, eventID)
+	return s.save(entries)
+}
+
+func (s *FileDedupStore) load() (dedupEntries, error) {
+	entries := dedupEntries{}
+	content, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(content) == 0 {
+		return entries, nil
+	}
+	if err = json.Unmarshal(content, &entries); err != nil {
+		return nil, fmt.Errorf("error parsing deduplication store %s: %s", s.path, err.Error())
+	}
+	return entries, nil
+}
+
+// save replaces the file atomically, so a crash doesn't leave a truncated file behind
+func (s *FileDedupStore) save(entries dedupEntries) error {
+	content, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path))
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(content); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), s.path)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
+	"github.com/keptn/go-utils/pkg/lib/v0_2_0/fake"
+)
+
+func TestDedupStores(t *testing.T) {
+	dir, err := ioutil.TempDir("./test-tmp/", "dedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2021, 6, 22, 20, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	memoryStore := NewMemoryDedupStore(time.Hour)
+	memoryStore.now = clock
+	fileStore, err := NewFileDedupStore(filepath.Join(dir, "dedup.json"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileStore.now = clock
+
+	stores := map[string]DedupStore{"memory": memoryStore, "file": fileStore}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			now = time.Date(2021, 6, 22, 20, 0, 0, 0, time.UTC)
+
+			if existing, err := store.Claim("event-1"); err != nil || existing != nil {
+				t.Fatalf("Expected a new event to be claimed got %v/%v", existing, err)
+			}
+			existing, err := store.Claim("event-1")
+			if err != nil || existing == nil || existing.Outcome != nil {
+				t.Fatalf("Expected the running event to be known got %v/%v", existing, err)
+			}
+
+			outcome := &GatlingTestFinishedEventData{TestFinishedEventData: keptnv2.TestFinishedEventData{
+				EventData: keptnv2.EventData{Status: keptnv2.StatusSucceeded, Result: keptnv2.ResultPass, Message: "Gatling test finished successfully"},
+			}}
+			if err = store.Complete("event-1", outcome); err != nil {
+				t.Fatal(err)
+			}
+			existing, err = store.Claim("event-1")
+			if err != nil || existing == nil || existing.Outcome == nil || existing.Outcome.Message != outcome.Message {
+				t.Fatalf("Expected the outcome of the event got %v/%v", existing, err)
+			}
+
+			// the event is forgotten once the TTL is over
+			now = now.Add(time.Hour)
+			if existing, err = store.Claim("event-1"); err != nil || existing != nil {
+				t.Errorf("Expected the expired event to be claimed again got %v/%v", existing, err)
+			}
+
+			if err = store.Release("event-1"); err != nil {
+				t.Fatal(err)
+			}
+			if existing, err = store.Claim("event-1"); err != nil || existing != nil {
+				t.Errorf("Expected the released event to be claimed again got %v/%v", existing, err)
+			}
+		})
+	}
+}
+
+func TestFileDedupStoreRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("./test-tmp/", "dedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "dedup.json")
+	store, err := NewFileDedupStore(file, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = store.Claim("finished")
+	_, _ = store.Claim("interrupted")
+	_ = store.Complete("finished", &GatlingTestFinishedEventData{})
+
+	restarted, err := NewFileDedupStore(file, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if existing, _ := restarted.Claim("finished"); existing == nil || existing.Outcome == nil {
+		t.Errorf("Expected the finished event to survive the restart")
+	}
+	if existing, _ := restarted.Claim("interrupted"); existing != nil {
+		t.Errorf("Expected the interrupted event to be handled again after the restart")
+	}
+
+	if err = ioutil.WriteFile(file, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewFileDedupStore(file, time.Hour); err == nil {
+		t.Errorf("Expected an error for a corrupt file")
+	}
+}
+
+func TestHandleTestTriggeredEventRedelivered(t *testing.T) {
+	g, incomingEvent, specificEvent := initializeTestHandler(t, "test-data/simple/", "test-events/test.triggered.json", "gatling/user-files/simulations/SomeSimulation.scala")
+	myKeptn := g.myKeptn
+
+	executions := 0
+	release := make(chan struct{})
+	g.executionHandler = func(ctx context.Context, args []string, env []string, output io.Writer) (string, error) {
+		executions++
+		<-release
+		return "", copyResults(args, SimulationLogName)
+	}
+	g.dedupStore = NewMemoryDedupStore(time.Hour)
+
+	sentEvents := func() []string {
+		var types []string
+		for _, event := range myKeptn.EventSender.(*fake.EventSender).SentEvents {
+			types = append(types, event.Type())
+		}
+		return types
+	}
+
+	if err := g.HandleTestTriggeredEvent(*incomingEvent, specificEvent); err != nil {
+		t.Fatalf("Unexpected Error: %s", err.Error())
+	}
+	// redelivered while the test is running
+	if err := g.HandleTestTriggeredEvent(*incomingEvent, specificEvent); err != nil {
+		t.Fatalf("Unexpected Error: %s", err.Error())
+	}
+	if events := sentEvents(); len(events) != 1 {
+		t.Errorf("Expected only the started event of the original test got %v", events)
+	}
+
+	close(release)
+	g.runner.Wait()
+
+	// redelivered after the test finished
+	if err := g.HandleTestTriggeredEvent(*incomingEvent, specificEvent); err != nil {
+		t.Fatalf("Unexpected Error: %s", err.Error())
+	}
+	g.runner.Wait()
+
+	if executions != 1 {
+		t.Errorf("Expected the test to be executed once got %d", executions)
+	}
+	events := myKeptn.EventSender.(*fake.EventSender).SentEvents
+	if len(events) != 3 || events[2].Type() != keptnv2.GetFinishedEventType(keptnv2.TestTaskName) {
+		t.Fatalf("Expected the finished event to be sent again got %v", sentEvents())
+	}
+	original, reported := &GatlingTestFinishedEventData{}, &GatlingTestFinishedEventData{}
+	_ = events[1].DataAs(original)
+	_ = events[2].DataAs(reported)
+	if reported.Message != original.Message || reported.Result != keptnv2.ResultPass || reported.Gatling == nil || reported.Gatling.Results == nil {
+		t.Errorf("Expected the outcome of the original test got %+v", reported)
+	}
+}
+
+func TestHandleTestTriggeredEventRedeliveredAfterShutdown(t *testing.T) {
+	dir, err := ioutil.TempDir("./test-tmp/", "dedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	g, incomingEvent, specificEvent := initializeTestHandler(t, "test-data/simple/", "test-events/test.triggered.json", "gatling/user-files/simulations/SomeSimulation.scala")
+
+	started := make(chan struct{})
+	g.executionHandler = func(ctx context.Context, args []string, env []string, output io.Writer) (string, error) {
+		close(started)
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+	file := filepath.Join(dir, "dedup.json")
+	if g.dedupStore, err = NewFileDedupStore(file, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = g.HandleTestTriggeredEvent(*incomingEvent, specificEvent); err != nil {
+		t.Fatalf("Unexpected Error: %s", err.Error())
+	}
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_ = g.runner.Shutdown(ctx)
+
+	// the test.finished event of the aborted test is sent again when the event is redelivered after the restart
+	restarted, err := NewFileDedupStore(file, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing, err := restarted.Claim(incomingEvent.ID())
+	if err != nil || existing == nil || existing.Outcome == nil {
+		t.Fatalf("Expected the outcome of the aborted test got %+v/%v", existing, err)
+	}
+	if existing.Outcome.Status != keptnv2.StatusErrored || existing.Outcome.Message != "Gatling test was aborted because the service was shut down" {
+		t.Errorf("Expected the aborted test to be recorded as errored got %+v", existing.Outcome)
+	}
+}
+
+func TestHandleTestTriggeredEventRedeliveredAfterRejection(t *testing.T) {
+	g, incomingEvent, specificEvent := initializeTestHandler(t, "", "test-events/test.triggered.json")
+	myKeptn := g.myKeptn
+
+	// the queue is full, thus the test is rejected
+	g.runner = NewTestRunner(1, 0)
+	release := make(chan struct{})
+	_ = g.runner.Submit(&TestRun{ID: "blocking", Project: "other", Execute: func() error {
+		<-release
+		return nil
+	}})
+	defer func() {
+		close(release)
+		g.runner.Wait()
+	}()
+	g.dedupStore = NewMemoryDedupStore(time.Hour)
+
+	if err := g.HandleTestTriggeredEvent(*incomingEvent, specificEvent); err == nil {
+		t.Errorf("Expected an error for the rejected test run")
+	}
+	if err := g.HandleTestTriggeredEvent(*incomingEvent, specificEvent); err != nil {
+		t.Fatalf("Unexpected Error: %s", err.Error())
+	}
+
+	// the rejection is reported again without another test.started event
+	events := myKeptn.EventSender.(*fake.EventSender).SentEvents
+	if len(events) != 3 || events[2].Type() != keptnv2.GetFinishedEventType(keptnv2.TestTaskName) {
+		t.Fatalf("Expected the finished event to be sent again got %d events", len(events))
+	}
+	reported := &GatlingTestFinishedEventData{}
+	_ = events[2].DataAs(reported)
+	if reported.Status != keptnv2.StatusErrored || !strings.Contains(reported.Message, "test queue is full") {
+		t.Errorf("Expected the rejection to be reported again got %s: %s", reported.Status, reported.Message)
+	}
+}
@@ -1,12 +1,19 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	cloudevents "github.com/cloudevents/sdk-go/v2" // make sure to use v2 cloudevents here
 	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
 	log "github.com/sirupsen/logrus"
 	"io/ioutil"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -20,10 +27,56 @@ const (
 	ConfFilename   = "gatling.conf.yaml"
 )
 
+// GatlingTestFinishedEventData test.finished payload extended by the Gatling run details
+type GatlingTestFinishedEventData struct {
+	keptnv2.TestFinishedEventData
+	Gatling *GatlingFinishedDetails `json:"gatling,omitempty"`
+}
+
+// GatlingFinishedDetails details of the executed simulation
+type GatlingFinishedDetails struct {
+	Simulation   string               `json:"simulation"`
+	Results      *SimulationResults   `json:"results,omitempty"`
+	Assertions   []*AssertionResult   `json:"assertions,omitempty"`
+	ReportURI    string               `json:"reportURI,omitempty"`
+	ArtifactsURI string               `json:"artifactsURI,omitempty"`
+	RunID        string               `json:"runID,omitempty"`
+	Runs         []*ServiceURLResults `json:"runs,omitempty"`
+	Simulations  []*SimulationOutcome `json:"simulations,omitempty"`
+	Skipped      *SkippedDetails      `json:"skipped,omitempty"`
+}
+
+// ServiceURLResults results of a single deployment URI in case the simulation runs once per URI
+type ServiceURLResults struct {
+	ServiceURL string             `json:"serviceURL"`
+	Results    *SimulationResults `json:"results,omitempty"`
+}
+
+// SkippedDetails why no simulation was executed
+type SkippedDetails struct {
+	Reason       string `json:"reason"`
+	ResourcePath string `json:"resourcePath"`
+}
+
+// GatlingTestStatusChangedEventData test.status.changed payload extended by the progress of the run
+type GatlingTestStatusChangedEventData struct {
+	keptnv2.TestStatusChangedEventData
+	Gatling *TestProgress `json:"gatling,omitempty"`
+}
+
 type EventHandler struct {
 	tempPathPrefix string
 	confDirRoot string
 	executionHandler GatlingExecutionHandler
+	resultStore *ResultStore
+	lastRuns *LastRunCollector
+	runner *TestRunner
+	defaultTimeout time.Duration
+	statusInterval time.Duration
+	jvmOptions []string
+	missingResources string
+	artifactStore ArtifactStore
+	dedupStore DedupStore
 	myKeptn *keptnv2.Keptn
 }
 
@@ -31,21 +84,96 @@ type EventHandler struct {
 func (e *EventHandler) HandleTestTriggeredEvent(incomingEvent cloudevents.Event, data *keptnv2.TestTriggeredEventData) error {
 	log.Infof("Handling test.triggered Event: %s", incomingEvent.Context.GetID())
 
+	// nothing is sent while shutting down, thus the event is handled again when it's redelivered after the restart
+	if e.runner.Closed() {
+		return fmt.Errorf("service is shutting down, rejecting test.triggered Event %s", incomingEvent.Context.GetID())
+	}
+
+	// redelivered events are acknowledged without running the test again
+	if e.dedupStore != nil {
+		existing, err := e.dedupStore.Claim(incomingEvent.ID())
+		if err != nil {
+			log.Warnf("Failed to check whether test.triggered Event %s was redelivered, handling it anyway: %s", incomingEvent.ID(), err.Error())
+		} else if existing != nil {
+			return e.handleRedeliveredEvent(existing)
+		}
+	}
+
 	// Send out a test.started CloudEvent
 	_, err := e.myKeptn.SendTaskStartedEvent(&keptnv2.EventData{}, ServiceName)
 	if err != nil {
 		log.Errorf("Failed to send task started CloudEvent (%s), aborting... \n", err.Error())
+		e.releaseEvent()
 		return err
 	}
 
 	// CAPTURE START TIME
 	startTime := time.Now()
 
-	serviceURL, err := getServiceURL(data)
+	testContext, err := getTestContext(e.myKeptn, incomingEvent, data)
 	if err != nil {
 		return e.erroredTestsFinishedEvent(err)
 	}
 
+	// the test is executed in the background, the test.finished event is sent once it's done
+	err = e.runner.Submit(&TestRun{
+		ID:      incomingEvent.Context.GetID(),
+		Project: e.myKeptn.Event.GetProject(),
+		Execute: func() error {
+			executionStart := time.Now()
+			defer observeSince(testRunDuration, e.metricLabels(), executionStart)
+			return e.executeTest(startTime, data, testContext)
+		},
+		Cancel: func() error {
+			return e.sendTestFinishedEvent(startTime, keptnv2.StatusErrored, keptnv2.ResultFailed, "was not started because the service was shut down", nil)
+		},
+	})
+	if err != nil {
+		return e.erroredTestsFinishedEvent(err)
+	}
+	return nil
+}
+
+// handleRedeliveredEvent reports the outcome of the original test again, nothing is sent while it's still running
+func (e *EventHandler) handleRedeliveredEvent(entry *DedupEntry) error {
+	if entry.Outcome == nil {
+		log.Infof("Ignoring redelivered test.triggered Event %s, its test started at %s is still running", entry.EventID, entry.Claimed.Format(time.RFC3339))
+		return nil
+	}
+	log.Infof("Reporting the outcome of the redelivered test.triggered Event %s again", entry.EventID)
+	_, err := e.myKeptn.SendTaskFinishedEvent(entry.Outcome, ServiceName)
+	return err
+}
+
+// recordOutcome remembers the test.finished event sent for the handled event
+func (e *EventHandler) recordOutcome(outcome *GatlingTestFinishedEventData) {
+	if e.dedupStore == nil || e.myKeptn.CloudEvent == nil {
+		return
+	}
+	if err := e.dedupStore.Complete(e.myKeptn.CloudEvent.ID(), outcome); err != nil {
+		log.Warnf("Failed to record the outcome of test.triggered Event %s: %s", e.myKeptn.CloudEvent.ID(), err.Error())
+	}
+}
+
+// releaseEvent forgets the handled event, so it's handled again when it's redelivered
+func (e *EventHandler) releaseEvent() {
+	if e.dedupStore == nil || e.myKeptn.CloudEvent == nil {
+		return
+	}
+	if err := e.dedupStore.Release(e.myKeptn.CloudEvent.ID()); err != nil {
+		log.Warnf("Failed to release test.triggered Event %s: %s", e.myKeptn.CloudEvent.ID(), err.Error())
+	}
+}
+
+// executeTest runs the Gatling simulation and reports the outcome with a test.finished event
+func (e *EventHandler) executeTest(startTime time.Time, data *keptnv2.TestTriggeredEventData, testContext *TestContext) error {
+	runCtx := e.runner.Context()
+
+	// fail early, the URIs actually used depend on the workload
+	if _, err := getServiceURL(data); err != nil {
+		return e.erroredTestsFinishedEvent(err)
+	}
+
 	// create a tempdir
 	tempDir, err := ioutil.TempDir(e.tempPathPrefix, ResourcePrefix)
 	if err != nil {
@@ -55,7 +183,9 @@ func (e *EventHandler) HandleTestTriggeredEvent(incomingEvent cloudevents.Event,
 	// cleanup afterwards
 	defer os.RemoveAll(tempDir)
 
+	downloadStart := time.Now()
 	downloaded, err := getAllGatlingResources(e.myKeptn, e.myKeptn.Event.GetProject(), e.myKeptn.Event.GetStage(), e.myKeptn.Event.GetService(), tempDir)
+	observeSince(resourceDownloadDuration, e.metricLabels(), downloadStart)
 	if err != nil {
 		err = fmt.Errorf("error loading %s/* files for %s.%s.%s: %s", ResourcePrefix, e.myKeptn.Event.GetProject(), e.myKeptn.Event.GetStage(), e.myKeptn.Event.GetService(), err.Error())
 		return e.erroredTestsFinishedEvent(err)
@@ -63,7 +193,7 @@ func (e *EventHandler) HandleTestTriggeredEvent(incomingEvent cloudevents.Event,
 
 	// skipping when no configuration is present
 	if downloaded == 0 {
-		return e.sendSuccessfulTestFinishedEvent(startTime, "skipped")
+		return e.handleMissingResources(startTime)
 	}
 
 	err = restoreDefaultConfFiles(e.confDirRoot, tempDir)
@@ -73,50 +203,267 @@ func (e *EventHandler) HandleTestTriggeredEvent(incomingEvent cloudevents.Event,
 	}
 	var conf *GatlingConf
 	conf, err = getGatlingConf(e.myKeptn, e.myKeptn.Event.GetProject(), e.myKeptn.Event.GetStage(), e.myKeptn.Event.GetService())
-	if err != nil {
+	var validationErr *ConfValidationError
+	if errors.As(err, &validationErr) {
+		// a present but invalid file must not silently fall back to the defaults
+		return e.erroredTestsFinishedEvent(err)
+	} else if err != nil {
 		log.Warnf("Failed to load Configuration file: %s - proceeding with default values", err.Error())
 	}
 
-	simulation := determineSimulationName(data, conf)
+	workload := findWorkload(conf, testContext)
+	simulations, err := determineSimulations(data, workload)
+	if err != nil {
+		return e.erroredTestsFinishedEvent(err)
+	}
 
-	log.Infof("TestStrategy=%s -> simulation=%s -> serviceUrl=%s\n", data.Test.TestStrategy, simulation, serviceURL.String())
+	execution, err := determineExecution(workload)
+	if err != nil {
+		return e.erroredTestsFinishedEvent(err)
+	}
 
-	// -> https://github.com/keptn/keptn/blob/069dd0f5c7b6f37a3737f4c0c9c7cf07a801b039/jmeter-service/jmeterUtils.go#L184
-	command := []string{
-		fmt.Sprintf("--simulation=%s", simulation),
+	timeout, err := determineTimeout(workload, e.defaultTimeout)
+	if err != nil {
+		return e.erroredTestsFinishedEvent(err)
 	}
 
-	environment := os.Environ()
-	environment = append(environment, fmt.Sprintf("GATLING_HOME=%s", tempDir))
-	environment = append(environment, fmt.Sprintf("JAVA_OPTS=-DserviceURL=%s", serviceURL.String()))
-	log.Info("Running gatling tests")
-	str, err := e.executionHandler(command, environment)
+	properties, err := buildJavaProperties(workload, testContext)
+	if err != nil {
+		return e.erroredTestsFinishedEvent(err)
+	}
 
-	log.Infof("Finished running gatling tests")
-	log.Infof(str)
+	jvmOptions, err := buildJVMOptions(e.jvmOptions, workload)
+	if err != nil {
+		return e.erroredTestsFinishedEvent(err)
+	}
 
+	// mistakes in the assertions must not surface only after the whole load test ran
+	if workload != nil {
+		if err = validateAssertions(workload.Assertions); err != nil {
+			return e.erroredTestsFinishedEvent(fmt.Errorf("invalid assertions in %s: %s", ConfFilename, err.Error()))
+		}
+	}
+
+	serviceURLs, err := getServiceURLs(data, workloadDeploymentURIs(workload))
 	if err != nil {
 		return e.erroredTestsFinishedEvent(err)
 	}
 
-	return e.sendSuccessfulTestFinishedEvent(startTime, "finished successfully")
+	log.Infof("TestStrategy=%s -> simulations=%v -> serviceUrls=%v\n", data.Test.TestStrategy, simulations, serviceURLs)
+
+	// Gatling names the folder of a run by simulation and timestamp, thus the results are written
+	// into a folder named by the run ID of the Keptn sequence
+	runID := testContext.RunID()
+	plan := &simulationPlan{
+		gatlingHome: tempDir,
+		resultsDir:  filepath.Join(tempDir, ResultsDirname, runID),
+		runs:        [][]*url.URL{serviceURLs},
+		testContext: testContext,
+		jvmOptions:  jvmOptions,
+		properties:  properties,
+	}
+
+	// a single run against all URIs unless the simulation runs once per URI
+	if workload != nil && workload.RunPerURI {
+		plan.runs = nil
+		for _, serviceURL := range serviceURLs {
+			plan.runs = append(plan.runs, []*url.URL{serviceURL})
+		}
+	}
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(runCtx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(runCtx)
+	}
+	defer cancel()
+
+	outcomes := e.executeSimulations(ctx, plan, simulations, execution, workload != nil && workload.StopOnFailure)
+
+	details := &GatlingFinishedDetails{
+		Simulation: strings.Join(simulations, ","),
+		RunID:      runID,
+	}
+	if len(outcomes) == 1 {
+		details.Runs = outcomes[0].Runs
+	} else {
+		details.Simulations = outcomes
+	}
+
+	if ctx.Err() != nil {
+		// report whatever was recorded until the simulation was killed
+		details.Results, err = loadSimulationResults(plan.resultsDir)
+		if err != nil {
+			log.Warnf("Failed to parse partial gatling results: %s", err.Error())
+		}
+		if runCtx.Err() != nil {
+			return e.sendTestFinishedEvent(startTime, keptnv2.StatusErrored, keptnv2.ResultFailed, "was aborted because the service was shut down", details)
+		}
+		message := fmt.Sprintf("timed out after %s and was aborted", timeout)
+		return e.sendTestFinishedEvent(startTime, keptnv2.StatusErrored, keptnv2.ResultFailed, message, details)
+	}
+
+	var assertions []*AssertionResult
+	for _, outcome := range outcomes {
+		if outcome.Status == SimulationErrored {
+			if len(outcomes) == 1 {
+				return e.erroredTestsFinishedEvent(outcome.err)
+			}
+			message := fmt.Sprintf("errored in simulation %s: %s", outcome.Simulation, outcome.Message)
+			return e.sendTestFinishedEvent(startTime, keptnv2.StatusErrored, keptnv2.ResultFailed, message, details)
+		}
+		assertions = append(assertions, outcome.Assertions...)
+	}
+
+	results, err := loadSimulationResults(plan.resultsDir)
+	if err != nil {
+		return e.erroredTestsFinishedEvent(fmt.Errorf("error parsing gatling results: %s", err.Error()))
+	}
+
+	// assertions of gatling.conf.yaml are evaluated by the service itself against the results of all simulations
+	if workload != nil && len(workload.Assertions) > 0 {
+		evaluated, err := evaluateAssertions(workload.Assertions, results)
+		if err != nil {
+			return e.erroredTestsFinishedEvent(fmt.Errorf("error evaluating assertions of %s: %s", ConfFilename, err.Error()))
+		}
+		assertions = append(assertions, evaluated...)
+	}
+	failed := failedAssertions(assertions)
+
+	if results != nil && e.resultStore != nil {
+		e.resultStore.Put(e.myKeptn.KeptnContext, e.myKeptn.Event.GetProject(), e.myKeptn.Event.GetStage(), e.myKeptn.Event.GetService(), results)
+	}
+	if e.lastRuns != nil {
+		for _, outcome := range outcomes {
+			e.lastRuns.Record(e.myKeptn.Event.GetProject(), e.myKeptn.Event.GetStage(), e.myKeptn.Event.GetService(), outcome.Simulation, outcome.Results)
+		}
+	}
+
+	details.Results = results
+	details.Assertions = assertions
+
+	// a failed upload shouldn't affect the outcome of the test
+	if e.artifactStore != nil {
+		// the run ID is unique per test task, a sequence may trigger several tests of the service
+		prefix := path.Join(e.myKeptn.Event.GetProject(), e.myKeptn.Event.GetStage(), e.myKeptn.Event.GetService(), runID)
+		details.ArtifactsURI, err = storeRunArtifacts(e.artifactStore, prefix, plan.resultsDir, details)
+		if err != nil {
+			log.Warnf("Failed to store the artifacts of the test run: %s", err.Error())
+		}
+	}
+
+	details.ReportURI, err = uploadReports(e.myKeptn, conf, plan.resultsDir)
+	if err != nil {
+		log.Warnf("Failed to upload the Gatling reports: %s", err.Error())
+	}
+
+	switch assertionsVerdict(assertions) {
+	case keptnv2.ResultFailed:
+		message := fmt.Sprintf("failed %d assertion(s): %s", len(failed), describeAssertions(failed))
+		return e.sendTestFinishedEvent(startTime, keptnv2.StatusSucceeded, keptnv2.ResultFailed, message, details)
+	case keptnv2.ResultWarning:
+		message := fmt.Sprintf("finished with %d warning(s): %s", len(failed), describeAssertions(failed))
+		return e.sendTestFinishedEvent(startTime, keptnv2.StatusSucceeded, keptnv2.ResultWarning, message, details)
+	}
+
+	return e.sendSuccessfulTestFinishedEvent(startTime, "finished successfully", details)
+}
+
+// progressReporter sends test.status.changed events based on the console output, at most once per status interval
+func (e *EventHandler) progressReporter() io.Writer {
+	if e.statusInterval <= 0 {
+		return nil
+	}
+	var lastSent time.Time
+	return newProgressWriter(func(progress *TestProgress) {
+		if time.Since(lastSent) < e.statusInterval {
+			return
+		}
+		lastSent = time.Now()
+
+		statusEvent := &GatlingTestStatusChangedEventData{
+			TestStatusChangedEventData: keptnv2.TestStatusChangedEventData{
+				EventData: keptnv2.EventData{
+					Status: keptnv2.StatusSucceeded,
+					Message: fmt.Sprintf("Gatling test running for %s: %d requests (OK=%d KO=%d), %d active users, %d done, %d waiting",
+						progress.Elapsed, progress.Requests, progress.OK, progress.KO, progress.UsersActive, progress.UsersDone, progress.UsersWaiting),
+				},
+			},
+			Gatling: progress,
+		}
+		if _, err := e.myKeptn.SendTaskStatusChangedEvent(statusEvent, ServiceName); err != nil {
+			log.Warnf("Failed to send test status changed event: %s", err.Error())
+		}
+	})
+}
+
+func (e *EventHandler) sendSuccessfulTestFinishedEvent(startTime time.Time, message string, details *GatlingFinishedDetails) error {
+	return e.sendTestFinishedEvent(startTime, keptnv2.StatusSucceeded, keptnv2.ResultPass, message, details)
+}
+
+// handleMissingResources reports a test without Gatling resources according to the missing resources policy,
+// the policy of the service is overridden by the one of gatling.conf.yaml on project level
+func (e *EventHandler) handleMissingResources(startTime time.Time) error {
+	project, stage, service := e.myKeptn.Event.GetProject(), e.myKeptn.Event.GetStage(), e.myKeptn.Event.GetService()
+
+	policy := e.missingResources
+	conf, err := getGatlingConf(e.myKeptn, project, stage, service)
+	var validationErr *ConfValidationError
+	if errors.As(err, &validationErr) {
+		return e.erroredTestsFinishedEvent(err)
+	} else if err != nil {
+		log.Warnf("Failed to load Configuration file: %s - proceeding with the default missing resources policy", err.Error())
+	} else if conf != nil && conf.MissingResources != "" {
+		policy = conf.MissingResources
+	}
+
+	skipped := &SkippedDetails{
+		Reason:       fmt.Sprintf("no %s/* resources found for service %s in stage %s of project %s", ResourcePrefix, service, stage, project),
+		ResourcePath: path.Join(project, stage, service, ResourcePrefix) + "/",
+	}
+	log.Warnf("Gatling test skipped, %s (policy %s)", skipped.Reason, policy)
+
+	details := &GatlingFinishedDetails{Skipped: skipped}
+	switch policy {
+	case MissingResourcesWarning:
+		return e.sendTestFinishedEvent(startTime, keptnv2.StatusSucceeded, keptnv2.ResultWarning, "skipped: "+skipped.Reason, details)
+	case MissingResourcesFail:
+		return e.sendTestFinishedEvent(startTime, keptnv2.StatusSucceeded, keptnv2.ResultFailed, "failed: "+skipped.Reason, details)
+	}
+	return e.sendSuccessfulTestFinishedEvent(startTime, "skipped: "+skipped.Reason, details)
+}
+
+// workloadDeploymentURIs returns which deployment URIs are used by the workload
+func workloadDeploymentURIs(workload *Workload) string {
+	if workload == nil {
+		return DeploymentURIsAuto
+	}
+	return workload.DeploymentURIs
 }
 
-func (e *EventHandler) sendSuccessfulTestFinishedEvent(startTime time.Time, message string) error {
+// sendTestFinishedEvent reports a test run which was executed, including its (partial) results
+func (e *EventHandler) sendTestFinishedEvent(startTime time.Time, status keptnv2.StatusType, result keptnv2.ResultType, message string, details *GatlingFinishedDetails) error {
 	endTime := time.Now()
-	finishedEvent := &keptnv2.TestFinishedEventData{
-		Test: keptnv2.TestFinishedDetails{
-			Start: startTime.Format(time.RFC3339),
-			End:   endTime.Format(time.RFC3339),
-		},
-		EventData: keptnv2.EventData{
-			Result:  keptnv2.ResultPass,
-			Status:  keptnv2.StatusSucceeded,
-			Message: fmt.Sprintf("Gatling test %s", message),
+	finishedEvent := &GatlingTestFinishedEventData{
+		TestFinishedEventData: keptnv2.TestFinishedEventData{
+			Test: keptnv2.TestFinishedDetails{
+				Start: startTime.Format(time.RFC3339),
+				End:   endTime.Format(time.RFC3339),
+			},
+			EventData: keptnv2.EventData{
+				Result:  result,
+				Status:  status,
+				Message: fmt.Sprintf("Gatling test %s", message),
+			},
 		},
+		Gatling: details,
 	}
 
 	// Finally: send out a test.finished CloudEvent
+	recordTestFinished(e.metricLabels(), string(result), string(status))
+	e.recordOutcome(finishedEvent)
 	_, err := e.myKeptn.SendTaskFinishedEvent(finishedEvent, ServiceName)
 	if err != nil {
 		return e.erroredTestsFinishedEvent(err)
@@ -135,10 +482,13 @@ func  (e *EventHandler) sendErroredTestsFinishedEvent(err error) error {
 	// report error
 	log.Error(err)
 	// send out a test.finished failed CloudEvent
-	_, err = e.myKeptn.SendTaskFinishedEvent(&keptnv2.EventData{
+	recordTestFinished(e.metricLabels(), string(keptnv2.ResultFailed), string(keptnv2.StatusErrored))
+	data := keptnv2.EventData{
 		Status:  keptnv2.StatusErrored,
 		Result:  keptnv2.ResultFailed,
 		Message: err.Error(),
-	}, ServiceName)
+	}
+	e.recordOutcome(&GatlingTestFinishedEventData{TestFinishedEventData: keptnv2.TestFinishedEventData{EventData: data}})
+	_, err = e.myKeptn.SendTaskFinishedEvent(&data, ServiceName)
 	return err
 }
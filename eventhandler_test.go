@@ -1,21 +1,29 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
 	b64 "encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"github.com/keptn/go-utils/pkg/lib/v0_2_0/fake"
 	log "github.com/sirupsen/logrus"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"path"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	keptn "github.com/keptn/go-utils/pkg/lib/keptn"
 	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2" // make sure to use v2 cloudevents here
 	keptnapimodels "github.com/keptn/go-utils/pkg/api/models"
@@ -49,10 +57,26 @@ func initializeTestObjects(configurationServiceURL string, eventFileName string)
 }
 
 func initializeTestServer(returnedResources keptnapimodels.Resources, sourcePath string) *httptest.Server {
+	return initializeTestServerWithUploads(returnedResources, sourcePath, nil)
+}
+
+// initializeTestServerWithUploads additionally records the uploaded resources
+func initializeTestServerWithUploads(returnedResources keptnapimodels.Resources, sourcePath string, uploaded *[]*keptnapimodels.Resource) *httptest.Server {
 	ts := httptest.NewServer(
 		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Add("Content-Type", "application/json")
 			log.Debugf("Request path %s", r.URL.Path)
+			if r.Method == http.MethodPost && uploaded != nil {
+				request := &keptnapimodels.Resources{}
+				_ = json.NewDecoder(r.Body).Decode(request)
+				for _, resource := range request.Resources {
+					content, _ := b64.StdEncoding.DecodeString(resource.ResourceContent)
+					resource.ResourceContent = string(content)
+					*uploaded = append(*uploaded, resource)
+				}
+				_, _ = w.Write([]byte(`{"version": "1"}`))
+				return
+			}
 			if strings.HasSuffix(r.URL.Path, "/resource/") {
 				marshal, _ := json.Marshal(returnedResources)
 				_, _ = w.Write(marshal)
@@ -78,6 +102,49 @@ func initializeTestServer(returnedResources keptnapimodels.Resources, sourcePath
 	return ts
 }
 
+// testResources lists the resources served by the test server
+func testResources(resourceURIs ...string) keptnapimodels.Resources {
+	resources := keptnapimodels.Resources{}
+	for i := range resourceURIs {
+		resources.Resources = append(resources.Resources, &keptnapimodels.Resource{ResourceURI: &resourceURIs[i]})
+	}
+	return resources
+}
+
+// initializeTestHandler serves the resources from sourcePath and returns an event handler for the event of the file
+func initializeTestHandler(t *testing.T, sourcePath string, eventFileName string, resourceURIs ...string) (*EventHandler, *cloudevents.Event, *keptnv2.TestTriggeredEventData) {
+	return initializeTestHandlerWithServer(t, initializeTestServer(testResources(resourceURIs...), sourcePath), eventFileName)
+}
+
+// initializeTestHandlerWithUploads additionally records the uploaded resources
+func initializeTestHandlerWithUploads(t *testing.T, uploaded *[]*keptnapimodels.Resource, sourcePath string, eventFileName string, resourceURIs ...string) (*EventHandler, *cloudevents.Event, *keptnv2.TestTriggeredEventData) {
+	return initializeTestHandlerWithServer(t, initializeTestServerWithUploads(testResources(resourceURIs...), sourcePath, uploaded), eventFileName)
+}
+
+// initializeTestHandlerWithServer returns an event handler for the event of the file, which loads the resources
+// from the test server. The server is closed at the end of the test.
+func initializeTestHandlerWithServer(t *testing.T, ts *httptest.Server, eventFileName string) (*EventHandler, *cloudevents.Event, *keptnv2.TestTriggeredEventData) {
+	t.Cleanup(ts.Close)
+
+	myKeptn, incomingEvent, err := initializeTestObjects(ts.URL, eventFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	specificEvent := &keptnv2.TestTriggeredEventData{}
+	if err = incomingEvent.DataAs(specificEvent); err != nil {
+		t.Fatal(err)
+	}
+
+	g := &EventHandler{
+		confDirRoot:    path.Join([]string{"test-data", "dist"}...),
+		tempPathPrefix: "./test-tmp/",
+		runner:         NewTestRunner(1, 1),
+		myKeptn:        myKeptn,
+	}
+	return g, incomingEvent, specificEvent
+}
+
 func assetStartedAndFinishedEvents(t *testing.T, gotEvents int, myKeptn *keptnv2.Keptn) {
 	// Verify that HandleTestTriggeredEvent has sent 2 cloudevents
 	if gotEvents != 2 {
@@ -116,6 +183,16 @@ func TestHandleTestTriggeredEvent(t *testing.T) {
 		},
 	}
 
+	contentUriWithAssertions := "gatling/user-files/simulations/SomeSimulation.scala"
+	resourcesWithAssertions := []*keptnapimodels.Resource{
+		{
+			ResourceURI: &contentUriWithAssertions,
+		},
+		{
+			ResourceURI: &configUriWithConfig,
+		},
+	}
+
 	// tests cases
 	type test struct {
 		name               string
@@ -124,6 +201,7 @@ func TestHandleTestTriggeredEvent(t *testing.T) {
 		resources          []*keptnapimodels.Resource
 		executionHandler   GatlingExecutionHandler
 		expectedResult     keptnv2.ResultType
+		expectedStatus     keptnv2.StatusType
 		expectedMessage    string
 	}
 
@@ -135,7 +213,8 @@ func TestHandleTestTriggeredEvent(t *testing.T) {
 			resourcesEmpty,
 			nil,
 			keptnv2.ResultPass,
-			"Gatling test skipped",
+			keptnv2.StatusSucceeded,
+			"Gatling test skipped: no gatling/* resources found for service helloservice in stage hardening of project pod-tato-head",
 		},
 		{
 			"Fail when deploymentUri is missing",
@@ -144,6 +223,7 @@ func TestHandleTestTriggeredEvent(t *testing.T) {
 			resourcesEmpty,
 			nil,
 			keptnv2.ResultFailed,
+			keptnv2.StatusErrored,
 			"no deployment URI included in event",
 		},
 		{
@@ -151,19 +231,35 @@ func TestHandleTestTriggeredEvent(t *testing.T) {
 			"test-events/test.triggered.json",
 			"test-data/simple/",
 			resourcesSimple,
-			func(args []string, env []string) (string, error) {
+			func(ctx context.Context, args []string, env []string, output io.Writer) (string, error) {
 				return "", errors.New("execution failed")
 			},
 			keptnv2.ResultFailed,
+			keptnv2.StatusErrored,
 			"execution failed",
 		},
+		{
+			"Fail if assertions don't succeed",
+			"test-events/test.triggered.json",
+			"test-data/simple/",
+			resourcesSimple,
+			func(ctx context.Context, args []string, env []string, output io.Writer) (string, error) {
+				if err := copyResults(args, SimulationLogName, path.Join("js", AssertionsFilename)); err != nil {
+					return "", err
+				}
+				return "", errors.New("exit status 2")
+			},
+			keptnv2.ResultFailed,
+			keptnv2.StatusSucceeded,
+			"Gatling test failed 1 assertion(s): Global: max of response time is less than 300 (actual: 400)",
+		},
 		{
 			"Successful test run - simple",
 			"test-events/test.triggered.json",
 			"test-data/simple/",
 			resourcesSimple,
-			func(args []string, env []string) (string, error) {
-				if len(args) != 1 {
+			func(ctx context.Context, args []string, env []string, output io.Writer) (string, error) {
+				if len(args) != 3 {
 					t.Errorf("Unexpected execution arguments")
 				}
 
@@ -171,9 +267,20 @@ func TestHandleTestTriggeredEvent(t *testing.T) {
 					t.Errorf("Unexpected simulation argument got %s", args[0])
 				}
 
+				expectedDescription := "--run-description=Keptn pod-tato-head/hardening/helloservice some (context 932ebf71-1f7b-46cd-9f3c-521ff969e321, event a3ec6695-3d11-460b-869a-7d88e2df6314)"
+				if args[1] != expectedDescription {
+					t.Errorf("Expected %s got %s", expectedDescription, args[1])
+				}
+
+				expectedFolder := path.Join(ResultsDirname, "932ebf71-1f7b-46cd-9f3c-521ff969e321-a3ec6695-3d11-460b-869a-7d88e2df6314")
+				if !strings.HasPrefix(args[2], "--results-folder=") || !strings.HasSuffix(args[2], expectedFolder) {
+					t.Errorf("Expected a results folder ending with %s got %s", expectedFolder, args[2])
+				}
+
 				return "", nil
 			},
 			keptnv2.ResultPass,
+			keptnv2.StatusSucceeded,
 			"Gatling test finished successfully",
 		},
 		{
@@ -181,8 +288,8 @@ func TestHandleTestTriggeredEvent(t *testing.T) {
 			"test-events/test.triggered.json",
 			"test-data/with-configuration/",
 			resourcesWithConfig,
-			func(args []string, env []string) (string, error) {
-				if len(args) != 1 {
+			func(ctx context.Context, args []string, env []string, output io.Writer) (string, error) {
+				if len(args) != 3 {
 					t.Errorf("Unexpected execution arguments")
 				}
 
@@ -193,6 +300,66 @@ func TestHandleTestTriggeredEvent(t *testing.T) {
 				return "", nil
 			},
 			keptnv2.ResultPass,
+			keptnv2.StatusSucceeded,
+			"Gatling test finished successfully",
+		},
+		{
+			"Warning if configured assertions are not met",
+			"test-events/test.triggered.json",
+			"test-data/with-assertions/",
+			resourcesWithAssertions,
+			func(ctx context.Context, args []string, env []string, output io.Writer) (string, error) {
+				return "", copyResults(args, SimulationLogName)
+			},
+			keptnv2.ResultWarning,
+			keptnv2.StatusSucceeded,
+			"Gatling test finished with 1 warning(s): Global: response_time_p95 is at most 300 (actual: 400)",
+		},
+		{
+			"Fail if the configuration is invalid",
+			"test-events/test.triggered.json",
+			"test-data/invalid-conf/",
+			resourcesWithAssertions,
+			nil,
+			keptnv2.ResultFailed,
+			keptnv2.StatusErrored,
+			"Couldn't parse gatling/gatling.conf.yaml file found for service helloservice in stage hardening in project pod-tato-head. Error: " +
+				"invalid gatling.conf.yaml of project pod-tato-head: line 5, column 5: unknown key workloads[0].simualtion",
+		},
+		{
+			"Fail before the test run if the assertions are invalid",
+			"test-events/test.triggered.json",
+			"test-data/invalid-assertions/",
+			resourcesWithAssertions,
+			nil,
+			keptnv2.ResultFailed,
+			keptnv2.StatusErrored,
+			"invalid assertions in gatling.conf.yaml: assertion for error_rate requires a min or max value",
+		},
+		{
+			"Successful test run - with properties",
+			"test-events/test.triggered.json",
+			"test-data/with-properties/",
+			resourcesWithAssertions,
+			func(ctx context.Context, args []string, env []string, output io.Writer) (string, error) {
+				expected := "JAVA_OPTS=-Xmx2G -XX:+UseG1GC " +
+					"-Denvironment=pod-tato-head-hardening-duplicate -DrampDuration=60 -Dusers=10 " +
+					"-Dkeptn.project=pod-tato-head -Dkeptn.stage=hardening -Dkeptn.service=helloservice -Dkeptn.context=932ebf71-1f7b-46cd-9f3c-521ff969e321 " +
+					"-Dkeptn.eventId=a3ec6695-3d11-460b-869a-7d88e2df6314 -Dkeptn.testStrategy=some -Dkeptn.deploymentStrategy=duplicate " +
+					"-Dkeptn.gitCommit=a6a97648be5668a7e01d14a0c41b50d5f317db76 -Dkeptn.image=ghcr.io/podtato-head/podtatoserver:v0.1.1 " +
+					"-DserviceURL=http://helloservice.pod-tato-head-hardening:80 -DserviceURLs=http://helloservice.pod-tato-head-hardening:80 -DserviceURLs.0=http://helloservice.pod-tato-head-hardening:80"
+				for _, variable := range env {
+					if strings.HasPrefix(variable, "JAVA_OPTS=") && variable != expected {
+						t.Errorf("Expected %s got %s", expected, variable)
+					}
+					if strings.HasPrefix(variable, "KEPTN_STAGE=") && variable != "KEPTN_STAGE=hardening" {
+						t.Errorf("Expected KEPTN_STAGE=hardening got %s", variable)
+					}
+				}
+				return "", nil
+			},
+			keptnv2.ResultPass,
+			keptnv2.StatusSucceeded,
 			"Gatling test finished successfully",
 		},
 	}
@@ -217,7 +384,7 @@ func TestHandleTestTriggeredEvent(t *testing.T) {
 				return
 			}
 
-			executionHandler := func(args []string, env []string) (string, error) {
+			executionHandler := func(ctx context.Context, args []string, env []string, output io.Writer) (string, error) {
 				t.Errorf("Unexpected execution call")
 				return "", nil
 			}
@@ -229,13 +396,15 @@ func TestHandleTestTriggeredEvent(t *testing.T) {
 				confDirRoot:      path.Join([]string{"test-data", "dist"}...),
 				tempPathPrefix:   "./test-tmp/",
 				executionHandler: executionHandler,
+				runner: NewTestRunner(1, 1),
 				myKeptn: myKeptn,
 			}
 
 			err = g.HandleTestTriggeredEvent(*incomingEvent, specificEvent)
-			if err != nil && testCase.expectedResult != keptnv2.ResultFailed && err.Error() != testCase.expectedMessage {
+			if err != nil {
 				t.Errorf("Unexpected Error: " + err.Error())
 			}
+			g.runner.Wait()
 
 			gotEvents := len(myKeptn.EventSender.(*fake.EventSender).SentEvents)
 
@@ -246,6 +415,9 @@ func TestHandleTestTriggeredEvent(t *testing.T) {
 				t.Errorf("Error getting keptn event data")
 				return
 			}
+			if sentEvent.Status != testCase.expectedStatus {
+				t.Errorf("Expected event status %s got: %s", testCase.expectedStatus, sentEvent.Status)
+			}
 			if sentEvent.Result != testCase.expectedResult {
 				t.Errorf("Expected event result %s got: %s", testCase.expectedResult, sentEvent.Result)
 			}
@@ -296,6 +468,56 @@ func TestGetServiceURL(t *testing.T) {
 	})
 }
 
+func TestGetServiceURLs(t *testing.T) {
+	data := &keptnv2.TestTriggeredEventData{
+		Deployment: keptnv2.TestTriggeredDeploymentDetails{
+			DeploymentURIsLocal:  []string{"http://blue.local:80", "", "http://green.local:80"},
+			DeploymentURIsPublic: []string{"http://blue.public"},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		data       *keptnv2.TestTriggeredEventData
+		preference string
+		expected   []string
+		expectErr  bool
+	}{
+		{"Prefers local URIs", data, DeploymentURIsAuto, []string{"http://blue.local:80", "http://green.local:80"}, false},
+		{"Local URIs", data, DeploymentURIsLocal, []string{"http://blue.local:80", "http://green.local:80"}, false},
+		{"Public URIs", data, DeploymentURIsPublic, []string{"http://blue.public"}, false},
+		{"Falls back to public URIs", &keptnv2.TestTriggeredEventData{
+			Deployment: keptnv2.TestTriggeredDeploymentDetails{DeploymentURIsPublic: []string{"http://blue.public"}},
+		}, DeploymentURIsAuto, []string{"http://blue.public"}, false},
+		{"Missing public URIs", &keptnv2.TestTriggeredEventData{
+			Deployment: keptnv2.TestTriggeredDeploymentDetails{DeploymentURIsLocal: []string{"http://blue.local:80"}},
+		}, DeploymentURIsPublic, nil, true},
+		{"Unknown preference", data, "internal", nil, true},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			serviceURLs, err := getServiceURLs(testCase.data, testCase.preference)
+			if testCase.expectErr {
+				if err == nil {
+					t.Errorf("Expected an error got %v", serviceURLs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			var got []string
+			for _, serviceURL := range serviceURLs {
+				got = append(got, serviceURL.String())
+			}
+			if strings.Join(got, " ") != strings.Join(testCase.expected, " ") {
+				t.Errorf("Expected %v got %v", testCase.expected, got)
+			}
+		})
+	}
+}
+
 func TestDetermineSimulationName(t *testing.T) {
 	data := &keptnv2.TestTriggeredEventData{
 		Test: keptnv2.TestTriggeredDetails{
@@ -304,7 +526,7 @@ func TestDetermineSimulationName(t *testing.T) {
 	}
 
 	t.Run("Missing config", func(t *testing.T) {
-		simulation := determineSimulationName(data, nil)
+		simulation := determineSimulationName(data)
 		if simulation != "CustomTestSimulation" {
 			t.Errorf("Expcted CustomTestSimulation got %s", simulation)
 		}
@@ -324,9 +546,766 @@ func TestDetermineSimulationName(t *testing.T) {
 			},
 		}
 
-		simulation := determineSimulationName(data, conf)
-		if simulation != "RandomSimulation" {
-			t.Errorf("Expcted CustomTestSimulation got %s", simulation)
+		simulations, err := determineSimulations(data, findWorkload(conf, &TestContext{TestStrategy: data.Test.TestStrategy}))
+		if err != nil || len(simulations) != 1 || simulations[0] != "RandomSimulation" {
+			t.Errorf("Expected RandomSimulation got %v/%v", simulations, err)
+		}
+	})
+}
+
+func TestTestContextVariables(t *testing.T) {
+	testContext := &TestContext{
+		Project:   "sockshop",
+		Stage:     "dev",
+		Service:   "carts",
+		GitCommit: "a6a97648",
+		Image:     "docker.io/keptnexamples/carts:0.12.1 latest",
+	}
+
+	properties := strings.Join(testContext.JavaProperties(), " ")
+	if properties != "-Dkeptn.project=sockshop -Dkeptn.stage=dev -Dkeptn.service=carts -Dkeptn.gitCommit=a6a97648" {
+		t.Errorf("Unexpected system properties %s", properties)
+	}
+
+	environment := strings.Join(testContext.Environment(), " ")
+	if environment != "KEPTN_PROJECT=sockshop KEPTN_STAGE=dev KEPTN_SERVICE=carts KEPTN_GIT_COMMIT=a6a97648 KEPTN_IMAGE=docker.io/keptnexamples/carts:0.12.1 latest" {
+		t.Errorf("Unexpected environment %s", environment)
+	}
+}
+
+func TestBuildJavaProperties(t *testing.T) {
+	testContext := &TestContext{Project: "sockshop", Stage: "dev", Service: "carts"}
+
+	tests := []struct {
+		name       string
+		properties map[string]string
+		expected   []string
+		expectErr  bool
+	}{
+		{"No properties", nil, nil, false},
+		{"Sorted by name", map[string]string{"users": "5", "duration": "30"}, []string{"-Dduration=30", "-Dusers=5"}, false},
+		{"Templated value", map[string]string{"target": "{{.Service}}.{{.Stage}}"}, []string{"-Dtarget=carts.dev"}, false},
+		{"Invalid name", map[string]string{"users count": "5"}, nil, true},
+		{"Value with whitespace", map[string]string{"users": "5 10"}, nil, true},
+		{"Unknown template field", map[string]string{"users": "{{.Users}}"}, nil, true},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			properties, err := buildJavaProperties(&Workload{Properties: testCase.properties}, testContext)
+			if testCase.expectErr {
+				if err == nil {
+					t.Errorf("Expected an error got %v", properties)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			if strings.Join(properties, " ") != strings.Join(testCase.expected, " ") {
+				t.Errorf("Expected %v got %v", testCase.expected, properties)
+			}
+		})
+	}
+}
+
+func TestBuildJVMOptions(t *testing.T) {
+	defaults := []string{"-Xmx1G"}
+
+	tests := []struct {
+		name       string
+		jvmOptions []string
+		expected   []string
+		expectErr  bool
+	}{
+		{"Defaults only", nil, []string{"-Xmx1G"}, false},
+		{"Workload options after defaults", []string{"-Xmx4g", "-XX:+UseG1GC", "-Dgatling.charting.noReports=true"}, []string{"-Xmx1G", "-Xmx4g", "-XX:+UseG1GC", "-Dgatling.charting.noReports=true"}, false},
+		{"Malformed heap size", []string{"-Xmx2T"}, nil, true},
+		{"Missing dash", []string{"Xmx2G"}, nil, true},
+		{"Java agent", []string{"-javaagent:/tmp/agent.jar"}, nil, true},
+		{"Command on out of memory", []string{"-XX:OnOutOfMemoryError=kill"}, nil, true},
+		{"Native agent library", []string{"-Xrunjdwp:transport=dt_socket"}, nil, true},
+		{"Debugging", []string{"-Xdebug"}, nil, true},
+		{"Logs to stdout", []string{"-Xlog:gc*:stdout:time"}, []string{"-Xmx1G", "-Xlog:gc*:stdout:time"}, false},
+		{"Logs to a file", []string{"-Xlog:gc:file=/tmp/gc.log"}, nil, true},
+		{"Logs to a file without prefix", []string{"-Xlog:gc:/tmp/gc.log"}, nil, true},
+		{"Legacy GC log file", []string{"-Xloggc:/tmp/gc.log"}, nil, true},
+		{"Heap dump path", []string{"-XX:HeapDumpPath=/etc/"}, nil, true},
+		{"Shell characters", []string{"-Dfoo=$(id)"}, nil, true},
+		{"Multiple options in one entry", []string{"-Xms1G -Xmx2G"}, nil, true},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			options, err := buildJVMOptions(defaults, &Workload{JVMOptions: testCase.jvmOptions})
+			if testCase.expectErr {
+				if err == nil {
+					t.Errorf("Expected an error got %v", options)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			if strings.Join(options, " ") != strings.Join(testCase.expected, " ") {
+				t.Errorf("Expected %v got %v", testCase.expected, options)
+			}
+		})
+	}
+}
+
+func TestFindWorkload(t *testing.T) {
+	conf := &GatlingConf{
+		Workloads: []*Workload{
+			{TestStrategy: "performance", Simulation: "DefaultSimulation"},
+			{TestStrategy: "performance", DeploymentNames: []string{"canary"}, Simulation: "CanarySimulation"},
+			{TestStrategy: "performance", DeploymentStrategy: "duplicate", Simulation: "DuplicateSimulation"},
+			{TestStrategy: "performance", Stage: "hardening", Simulation: "HardeningSimulation"},
+			{TestStrategy: "performance", Stage: "hardening", DeploymentStrategy: "duplicate", DeploymentNames: []string{"canary"}, Simulation: "HardeningCanarySimulation"},
+			{TestStrategy: "performance", Stage: "hardening", DeploymentStrategy: "duplicate", DeploymentNames: []string{"canary"}, Simulation: "UnusedSimulation"},
+			{TestStrategy: "functional", Simulation: "FunctionalSimulation"},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		testContext *TestContext
+		expected    string
+	}{
+		{"Most specific workload", &TestContext{TestStrategy: "performance", Stage: "hardening", DeploymentStrategy: "duplicate", DeploymentNames: []string{"canary"}}, "HardeningCanarySimulation"},
+		{"Stage before deployment strategy", &TestContext{TestStrategy: "performance", Stage: "hardening", DeploymentStrategy: "duplicate", DeploymentNames: []string{"primary"}}, "HardeningSimulation"},
+		{"Deployment strategy before deployment names", &TestContext{TestStrategy: "performance", Stage: "dev", DeploymentStrategy: "duplicate", DeploymentNames: []string{"canary"}}, "DuplicateSimulation"},
+		{"Deployment names", &TestContext{TestStrategy: "performance", Stage: "dev", DeploymentStrategy: "direct", DeploymentNames: []string{"direct", "canary"}}, "CanarySimulation"},
+		{"Without selectors", &TestContext{TestStrategy: "performance", Stage: "dev", DeploymentStrategy: "direct"}, "DefaultSimulation"},
+		{"Other teststrategy", &TestContext{TestStrategy: "functional", Stage: "hardening"}, "FunctionalSimulation"},
+		{"Unknown teststrategy", &TestContext{TestStrategy: "soak"}, ""},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			workload := findWorkload(conf, testCase.testContext)
+			simulation := ""
+			if workload != nil {
+				simulation = workload.Simulation
+			}
+			if simulation != testCase.expected {
+				t.Errorf("Expected %s got %s", testCase.expected, simulation)
+			}
+		})
+	}
+}
+
+func TestDetermineSimulations(t *testing.T) {
+	data := &keptnv2.TestTriggeredEventData{
+		Test: keptnv2.TestTriggeredDetails{
+			TestStrategy: "performance",
+		},
+	}
+
+	tests := []struct {
+		name      string
+		workload  *Workload
+		expected  []string
+		expectErr bool
+	}{
+		{"Without workload", nil, []string{"PerformanceSimulation"}, false},
+		{"Single simulation", &Workload{TestStrategy: "performance", Simulation: "LoadSimulation"}, []string{"LoadSimulation"}, false},
+		{"Simulation followed by simulations", &Workload{TestStrategy: "performance", Simulation: "WarmUpSimulation", Simulations: []string{"LoadSimulation"}}, []string{"WarmUpSimulation", "LoadSimulation"}, false},
+		{"Duplicate simulations", &Workload{TestStrategy: "performance", Simulations: []string{"LoadSimulation", "LoadSimulation"}}, nil, true},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			simulations, err := determineSimulations(data, testCase.workload)
+			if testCase.expectErr {
+				if err == nil {
+					t.Errorf("Expected an error got %v", simulations)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			if strings.Join(simulations, " ") != strings.Join(testCase.expected, " ") {
+				t.Errorf("Expected %v got %v", testCase.expected, simulations)
+			}
+		})
+	}
+
+	if _, err := determineExecution(&Workload{Execution: "random"}); err == nil {
+		t.Errorf("Expected an error for an unknown execution")
+	}
+}
+
+// copyResults places the given fixtures from test-data/results into the results folder passed to Gatling
+func copyResults(args []string, files ...string) error {
+	resultsDir := ""
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--results-folder=") {
+			resultsDir = strings.TrimPrefix(arg, "--results-folder=")
+		}
+	}
+	runDir := path.Join(resultsDir, "somesimulation-20210622200213")
+	for _, file := range files {
+		content, err := ioutil.ReadFile(path.Join("test-data", "results", file))
+		if err != nil {
+			return err
+		}
+		target := path.Join(runDir, file)
+		if err = os.MkdirAll(path.Dir(target), 0700); err != nil {
+			return err
+		}
+		if err = ioutil.WriteFile(target, content, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestHandleTestTriggeredEventWithResults(t *testing.T) {
+	g, incomingEvent, specificEvent := initializeTestHandler(t, "test-data/simple/", "test-events/test.triggered.json", "gatling/user-files/simulations/SomeSimulation.scala")
+	myKeptn := g.myKeptn
+
+	g.executionHandler = func(ctx context.Context, args []string, env []string, output io.Writer) (string, error) {
+		return "", copyResults(args, SimulationLogName)
+	}
+	g.lastRuns = NewLastRunCollector()
+
+	if err := g.HandleTestTriggeredEvent(*incomingEvent, specificEvent); err != nil {
+		t.Fatalf("Unexpected Error: %s", err.Error())
+	}
+	g.runner.Wait()
+
+	gotEvents := len(myKeptn.EventSender.(*fake.EventSender).SentEvents)
+	assetStartedAndFinishedEvents(t, gotEvents, myKeptn)
+
+	sentEvent := &GatlingTestFinishedEventData{}
+	if err := myKeptn.EventSender.(*fake.EventSender).SentEvents[1].DataAs(sentEvent); err != nil {
+		t.Fatalf("Error getting keptn event data")
+	}
+	if sentEvent.Gatling == nil || sentEvent.Gatling.Results == nil {
+		t.Fatalf("Expected gatling results in the finished event")
+	}
+	if sentEvent.Gatling.Simulation != "SomeSimulation" {
+		t.Errorf("Expected simulation SomeSimulation got %s", sentEvent.Gatling.Simulation)
+	}
+	if sentEvent.Gatling.Results.Global.Count != 4 {
+		t.Errorf("Expected 4 requests got %d", sentEvent.Gatling.Results.Global.Count)
+	}
+	if sentEvent.Gatling.RunID != "932ebf71-1f7b-46cd-9f3c-521ff969e321-a3ec6695-3d11-460b-869a-7d88e2df6314" {
+		t.Errorf("Unexpected run ID %s", sentEvent.Gatling.RunID)
+	}
+	if count := testutil.CollectAndCount(g.lastRuns, "gatling_service_last_run_timestamp_seconds"); count != 1 {
+		t.Errorf("Expected the statistics of the run to be exposed as metrics got %d simulations", count)
+	}
+}
+
+func TestHandleTestTriggeredEventArtifacts(t *testing.T) {
+	g, incomingEvent, specificEvent := initializeTestHandler(t, "test-data/simple/", "test-events/test.triggered.json", "gatling/user-files/simulations/SomeSimulation.scala")
+	myKeptn := g.myKeptn
+
+	baseDir, err := ioutil.TempDir("./test-tmp/", "artifacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(baseDir)
+
+	g.executionHandler = func(ctx context.Context, args []string, env []string, output io.Writer) (string, error) {
+		return "", copyResults(args, SimulationLogName)
+	}
+	g.artifactStore = &FilesystemArtifactStore{BaseDir: baseDir}
+
+	if err = g.HandleTestTriggeredEvent(*incomingEvent, specificEvent); err != nil {
+		t.Fatalf("Unexpected Error: %s", err.Error())
+	}
+	g.runner.Wait()
+
+	sentEvent := &GatlingTestFinishedEventData{}
+	if err = myKeptn.EventSender.(*fake.EventSender).SentEvents[1].DataAs(sentEvent); err != nil {
+		t.Fatalf("Error getting keptn event data")
+	}
+	// a location per run, several tests of the sequence share the Keptn context
+	prefix := "pod-tato-head/hardening/helloservice/932ebf71-1f7b-46cd-9f3c-521ff969e321-a3ec6695-3d11-460b-869a-7d88e2df6314"
+	if sentEvent.Gatling == nil || sentEvent.Gatling.ArtifactsURI != g.artifactStore.Location(prefix) {
+		t.Errorf("Expected the artifacts at %s got %+v", prefix, sentEvent.Gatling)
+	}
+	runDir := path.Join(baseDir, prefix)
+	if _, err = os.Stat(path.Join(runDir, ArtifactSummaryName)); err != nil {
+		t.Errorf("Expected the summary of the run: %s", err.Error())
+	}
+}
+
+func TestHandleTestTriggeredEventAsync(t *testing.T) {
+	g, incomingEvent, specificEvent := initializeTestHandler(t, "test-data/simple/", "test-events/test.triggered.json", "gatling/user-files/simulations/SomeSimulation.scala")
+	myKeptn := g.myKeptn
+
+	executionStarted := make(chan struct{})
+	releaseExecution := make(chan struct{})
+	g.executionHandler = func(ctx context.Context, args []string, env []string, output io.Writer) (string, error) {
+		close(executionStarted)
+		<-releaseExecution
+		return "", nil
+	}
+
+	if err := g.HandleTestTriggeredEvent(*incomingEvent, specificEvent); err != nil {
+		t.Fatalf("Unexpected Error: %s", err.Error())
+	}
+
+	// the handler returns while the simulation is still running
+	<-executionStarted
+	if gotEvents := len(myKeptn.EventSender.(*fake.EventSender).SentEvents); gotEvents != 1 {
+		t.Errorf("Expected only the started event to be sent, but got %v", gotEvents)
+	}
+
+	close(releaseExecution)
+	g.runner.Wait()
+
+	gotEvents := len(myKeptn.EventSender.(*fake.EventSender).SentEvents)
+	assetStartedAndFinishedEvents(t, gotEvents, myKeptn)
+}
+
+func TestHandleTestTriggeredEventQueueFull(t *testing.T) {
+	g, incomingEvent, specificEvent := initializeTestHandler(t, "", "test-events/test.triggered.json")
+	myKeptn := g.myKeptn
+
+	// occupy the only worker without any waiting slots
+	runner := NewTestRunner(1, 0)
+	release := make(chan struct{})
+	_ = runner.Submit(&TestRun{ID: "blocking", Project: "other", Execute: func() error {
+		<-release
+		return nil
+	}})
+	defer func() {
+		close(release)
+		runner.Wait()
+	}()
+
+	g.runner = runner
+
+	if err := g.HandleTestTriggeredEvent(*incomingEvent, specificEvent); err == nil {
+		t.Errorf("Expected an error for the rejected test run")
+	}
+
+	gotEvents := len(myKeptn.EventSender.(*fake.EventSender).SentEvents)
+	assetStartedAndFinishedEvents(t, gotEvents, myKeptn)
+
+	sentEvent := &keptnv2.TestFinishedEventData{}
+	if err := myKeptn.EventSender.(*fake.EventSender).SentEvents[1].DataAs(sentEvent); err != nil {
+		t.Fatalf("Error getting keptn event data")
+	}
+	if sentEvent.Status != keptnv2.StatusErrored || !strings.Contains(sentEvent.Message, "test queue is full") {
+		t.Errorf("Expected an errored event about the full queue got %s: %s", sentEvent.Status, sentEvent.Message)
+	}
+}
+
+func TestHandleTestTriggeredEventTimeout(t *testing.T) {
+	g, incomingEvent, specificEvent := initializeTestHandler(t, "test-data/simple/", "test-events/test.triggered.json", "gatling/user-files/simulations/SomeSimulation.scala")
+	myKeptn := g.myKeptn
+
+	g.executionHandler = func(ctx context.Context, args []string, env []string, output io.Writer) (string, error) {
+		if err := copyResults(args, SimulationLogName); err != nil {
+			return "", err
 		}
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+	g.defaultTimeout = 50 * time.Millisecond
+
+	if err := g.HandleTestTriggeredEvent(*incomingEvent, specificEvent); err != nil {
+		t.Fatalf("Unexpected Error: %s", err.Error())
+	}
+	g.runner.Wait()
+
+	gotEvents := len(myKeptn.EventSender.(*fake.EventSender).SentEvents)
+	assetStartedAndFinishedEvents(t, gotEvents, myKeptn)
+
+	sentEvent := &GatlingTestFinishedEventData{}
+	if err := myKeptn.EventSender.(*fake.EventSender).SentEvents[1].DataAs(sentEvent); err != nil {
+		t.Fatalf("Error getting keptn event data")
+	}
+	if sentEvent.Status != keptnv2.StatusErrored {
+		t.Errorf("Expected status %s got %s", keptnv2.StatusErrored, sentEvent.Status)
+	}
+	if sentEvent.Message != "Gatling test timed out after 50ms and was aborted" {
+		t.Errorf("Unexpected message %s", sentEvent.Message)
+	}
+	if sentEvent.Gatling == nil || sentEvent.Gatling.Results == nil {
+		t.Errorf("Expected partial results in the finished event")
+	}
+}
+
+func TestHandleTestTriggeredEventShutdown(t *testing.T) {
+	g, incomingEvent, specificEvent := initializeTestHandler(t, "test-data/simple/", "test-events/test.triggered.json", "gatling/user-files/simulations/SomeSimulation.scala")
+	myKeptn := g.myKeptn
+
+	started := make(chan struct{}, 1)
+	g.executionHandler = func(ctx context.Context, args []string, env []string, output io.Writer) (string, error) {
+		if err := copyResults(args, SimulationLogName); err != nil {
+			return "", err
+		}
+		started <- struct{}{}
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+
+	// the first test is running, the second one is queued
+	for i := 0; i < 2; i++ {
+		if err := g.HandleTestTriggeredEvent(*incomingEvent, specificEvent); err != nil {
+			t.Fatalf("Unexpected Error: %s", err.Error())
+		}
+	}
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := g.runner.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected the running test to be aborted got %v", err)
+	}
+
+	var messages []string
+	for _, event := range myKeptn.EventSender.(*fake.EventSender).SentEvents {
+		if event.Type() != keptnv2.GetFinishedEventType(keptnv2.TestTaskName) {
+			continue
+		}
+		sentEvent := &GatlingTestFinishedEventData{}
+		if err := event.DataAs(sentEvent); err != nil {
+			t.Fatalf("Error getting keptn event data")
+		}
+		if sentEvent.Status != keptnv2.StatusErrored {
+			t.Errorf("Expected status %s got %s", keptnv2.StatusErrored, sentEvent.Status)
+		}
+		messages = append(messages, sentEvent.Message)
+	}
+
+	// the queued test is reported right away, the running one once the drain period is over
+	expected := []string{
+		"Gatling test was not started because the service was shut down",
+		"Gatling test was aborted because the service was shut down",
+	}
+	if strings.Join(messages, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected %v got %v", expected, messages)
+	}
+
+	// further tests are rejected without sending any events
+	sent := len(myKeptn.EventSender.(*fake.EventSender).SentEvents)
+	if err := g.HandleTestTriggeredEvent(*incomingEvent, specificEvent); err == nil || !strings.Contains(err.Error(), "shutting down") {
+		t.Errorf("Expected the test to be rejected got %v", err)
+	}
+	if gotEvents := len(myKeptn.EventSender.(*fake.EventSender).SentEvents); gotEvents != sent {
+		t.Errorf("Expected no events for the rejected test got %d", gotEvents-sent)
+	}
+}
+
+func TestExecuteCommandWithEnvTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	// the forked sleep keeps the output open unless the whole process group is killed
+	_, err := ExecuteCommandWithEnv(ctx, "sh", []string{"-c", "sleep 30 & wait"}, []string{}, nil)
+	if err == nil || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected a deadline exceeded error got %v", err)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("Expected the command to be killed after the timeout")
+	}
+}
+
+func TestHandleTestTriggeredEventStatusChanged(t *testing.T) {
+	g, incomingEvent, specificEvent := initializeTestHandler(t, "test-data/simple/", "test-events/test.triggered.json", "gatling/user-files/simulations/SomeSimulation.scala")
+	myKeptn := g.myKeptn
+
+	g.executionHandler = func(ctx context.Context, args []string, env []string, output io.Writer) (string, error) {
+		content, err := ioutil.ReadFile(path.Join("test-data", "console", "gatling.out"))
+		if err != nil {
+			return "", err
+		}
+		_, err = output.Write(content)
+		return string(content), err
+	}
+	g.statusInterval = time.Nanosecond
+
+	if err := g.HandleTestTriggeredEvent(*incomingEvent, specificEvent); err != nil {
+		t.Fatalf("Unexpected Error: %s", err.Error())
+	}
+	g.runner.Wait()
+
+	eventSender := myKeptn.EventSender.(*fake.EventSender)
+	err := eventSender.AssertSentEventTypes([]string{
+		keptnv2.GetStartedEventType(keptnv2.TestTaskName),
+		keptnv2.GetStatusChangedEventType(keptnv2.TestTaskName),
+		keptnv2.GetStatusChangedEventType(keptnv2.TestTaskName),
+		keptnv2.GetFinishedEventType(keptnv2.TestTaskName),
 	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statusEvent := &GatlingTestStatusChangedEventData{}
+	if err := eventSender.SentEvents[2].DataAs(statusEvent); err != nil {
+		t.Fatalf("Error getting keptn event data")
+	}
+	expectedMessage := "Gatling test running for 10s: 27 requests (OK=25 KO=2), 4 active users, 12 done, 0 waiting"
+	if statusEvent.Message != expectedMessage {
+		t.Errorf("Expected message %s got %s", expectedMessage, statusEvent.Message)
+	}
+	if statusEvent.Gatling == nil || statusEvent.Gatling.Requests != 27 {
+		t.Errorf("Expected the progress in the status changed event")
+	}
+}
+
+func TestHandleTestTriggeredEventReportUpload(t *testing.T) {
+	var uploaded []*keptnapimodels.Resource
+	g, incomingEvent, specificEvent := initializeTestHandlerWithUploads(t, &uploaded, "test-data/with-report/", "test-events/test.triggered.json",
+		"gatling/user-files/simulations/SomeSimulation.scala", "gatling/gatling.conf.yaml", "gatling/reports/some-other-context/report.zip")
+	myKeptn := g.myKeptn
+
+	g.executionHandler = func(ctx context.Context, args []string, env []string, output io.Writer) (string, error) {
+		return "", copyResults(args, SimulationLogName, "index.html")
+	}
+
+	if err := g.HandleTestTriggeredEvent(*incomingEvent, specificEvent); err != nil {
+		t.Fatalf("Unexpected Error: %s", err.Error())
+	}
+	g.runner.Wait()
+
+	expectedUri := "gatling/reports/932ebf71-1f7b-46cd-9f3c-521ff969e321/report.zip"
+	if len(uploaded) != 1 || *uploaded[0].ResourceURI != expectedUri {
+		t.Fatalf("Expected the upload of %s", expectedUri)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader([]byte(uploaded[0].ResourceContent)), int64(len(uploaded[0].ResourceContent)))
+	if err != nil {
+		t.Fatalf("Expected a zip archive: %s", err.Error())
+	}
+	files := map[string]bool{}
+	for _, file := range archive.File {
+		files[file.Name] = true
+	}
+	if !files["somesimulation-20210622200213/index.html"] || files["somesimulation-20210622200213/simulation.log"] {
+		t.Errorf("Unexpected archive content %v", files)
+	}
+
+	sentEvent := &GatlingTestFinishedEventData{}
+	if err := myKeptn.EventSender.(*fake.EventSender).SentEvents[1].DataAs(sentEvent); err != nil {
+		t.Fatalf("Error getting keptn event data")
+	}
+	if sentEvent.Gatling == nil || sentEvent.Gatling.ReportURI != expectedUri {
+		t.Errorf("Expected the report URI %s in the finished event", expectedUri)
+	}
+}
+
+func TestHandleTestTriggeredEventPerURI(t *testing.T) {
+	g, incomingEvent, specificEvent := initializeTestHandler(t, "test-data/per-uri/", "test-events/test.triggered.multiple-uris.json",
+		"gatling/user-files/simulations/SomeSimulation.scala", "gatling/gatling.conf.yaml")
+	myKeptn := g.myKeptn
+
+	var serviceURLs []string
+	g.executionHandler = func(ctx context.Context, args []string, env []string, output io.Writer) (string, error) {
+		if len(args) != 3 || !strings.HasSuffix(args[2], fmt.Sprintf("uri-%d", len(serviceURLs))) {
+			t.Fatalf("Expected a results folder per run got %v", args)
+		}
+		for _, variable := range env {
+			if strings.HasPrefix(variable, "JAVA_OPTS=") {
+				serviceURLs = append(serviceURLs, variable[strings.Index(variable, "-DserviceURL="):])
+			}
+		}
+		return "", copyResults(args, SimulationLogName)
+	}
+
+	if err := g.HandleTestTriggeredEvent(*incomingEvent, specificEvent); err != nil {
+		t.Fatalf("Unexpected Error: %s", err.Error())
+	}
+	g.runner.Wait()
+
+	expected := []string{
+		"-DserviceURL=http://blue.helloservice.some.host:80 -DserviceURLs=http://blue.helloservice.some.host:80 -DserviceURLs.0=http://blue.helloservice.some.host:80",
+		"-DserviceURL=http://green.helloservice.some.host:80 -DserviceURLs=http://green.helloservice.some.host:80 -DserviceURLs.0=http://green.helloservice.some.host:80",
+	}
+	if strings.Join(serviceURLs, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected %v got %v", expected, serviceURLs)
+	}
+
+	sentEvent := &GatlingTestFinishedEventData{}
+	if err := myKeptn.EventSender.(*fake.EventSender).SentEvents[1].DataAs(sentEvent); err != nil {
+		t.Fatalf("Error getting keptn event data")
+	}
+	if sentEvent.Result != keptnv2.ResultPass {
+		t.Errorf("Expected result pass got %s: %s", sentEvent.Result, sentEvent.Message)
+	}
+	if sentEvent.Gatling == nil || sentEvent.Gatling.Results == nil || sentEvent.Gatling.Results.Global.Count != 8 {
+		t.Fatalf("Expected the aggregated results of both runs")
+	}
+	runs := sentEvent.Gatling.Runs
+	if len(runs) != 2 || runs[0].ServiceURL != "http://blue.helloservice.some.host:80" || runs[1].Results.Global.Count != 4 {
+		t.Errorf("Expected the results per deployment URI got %v", runs)
+	}
+}
+
+func TestHandleTestTriggeredEventMultipleSimulations(t *testing.T) {
+	tests := []struct {
+		name               string
+		resourceSourcePath string
+		executionHandler   func(ctx context.Context, simulation string, args []string) (string, error)
+		expectedStatus     keptnv2.StatusType
+		expectedResult     keptnv2.ResultType
+		expectedOutcomes   []string
+		// number of distinct binaries folders, simulations running in parallel must not share one
+		expectedBinaries int
+	}{
+		{
+			"Sequential simulations",
+			"test-data/multiple-simulations/sequential/",
+			func(ctx context.Context, simulation string, args []string) (string, error) {
+				return "", copyResults(args, SimulationLogName)
+			},
+			keptnv2.StatusSucceeded,
+			keptnv2.ResultPass,
+			[]string{"WarmUpSimulation=passed", "LoadSimulation=passed"},
+			0,
+		},
+		{
+			"Stop sequential simulations on failure",
+			"test-data/multiple-simulations/stop-on-failure/",
+			func(ctx context.Context, simulation string, args []string) (string, error) {
+				return "", errors.New("execution failed")
+			},
+			keptnv2.StatusErrored,
+			keptnv2.ResultFailed,
+			[]string{"WarmUpSimulation=errored", "LoadSimulation=skipped"},
+			0,
+		},
+		{
+			"Abort parallel simulations on failure",
+			"test-data/multiple-simulations/parallel/",
+			func(ctx context.Context, simulation string, args []string) (string, error) {
+				if simulation == "LoadSimulation" {
+					<-ctx.Done()
+					return "", ctx.Err()
+				}
+				if err := copyResults(args, SimulationLogName, path.Join("js", AssertionsFilename)); err != nil {
+					return "", err
+				}
+				return "", errors.New("exit status 2")
+			},
+			keptnv2.StatusSucceeded,
+			keptnv2.ResultFailed,
+			[]string{"WarmUpSimulation=failed", "LoadSimulation=aborted"},
+			2,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			g, incomingEvent, specificEvent := initializeTestHandler(t, testCase.resourceSourcePath, "test-events/test.triggered.json", "gatling/gatling.conf.yaml")
+			myKeptn := g.myKeptn
+
+			var mutex sync.Mutex
+			binaries := map[string]bool{}
+			g.executionHandler = func(ctx context.Context, args []string, env []string, output io.Writer) (string, error) {
+				for _, arg := range args {
+					if strings.HasPrefix(arg, "--binaries-folder=") {
+						mutex.Lock()
+						binaries[arg] = true
+						mutex.Unlock()
+					}
+				}
+				return testCase.executionHandler(ctx, strings.TrimPrefix(args[0], "--simulation="), args)
+			}
+
+			if err := g.HandleTestTriggeredEvent(*incomingEvent, specificEvent); err != nil {
+				t.Fatalf("Unexpected Error: %s", err.Error())
+			}
+			g.runner.Wait()
+
+			sentEvent := &GatlingTestFinishedEventData{}
+			if err := myKeptn.EventSender.(*fake.EventSender).SentEvents[1].DataAs(sentEvent); err != nil {
+				t.Fatalf("Error getting keptn event data")
+			}
+			if sentEvent.Status != testCase.expectedStatus || sentEvent.Result != testCase.expectedResult {
+				t.Errorf("Expected %s/%s got %s/%s: %s", testCase.expectedStatus, testCase.expectedResult, sentEvent.Status, sentEvent.Result, sentEvent.Message)
+			}
+			if sentEvent.Gatling == nil {
+				t.Fatalf("Expected gatling details in the finished event")
+			}
+
+			var outcomes []string
+			for _, outcome := range sentEvent.Gatling.Simulations {
+				outcomes = append(outcomes, fmt.Sprintf("%s=%s", outcome.Simulation, outcome.Status))
+			}
+			if strings.Join(outcomes, " ") != strings.Join(testCase.expectedOutcomes, " ") {
+				t.Errorf("Expected outcomes %v got %v", testCase.expectedOutcomes, outcomes)
+			}
+			if len(binaries) != testCase.expectedBinaries {
+				t.Errorf("Expected %d binaries folders got %v", testCase.expectedBinaries, binaries)
+			}
+		})
+	}
+}
+
+func TestHandleTestTriggeredEventMissingResources(t *testing.T) {
+	tests := []struct {
+		name            string
+		policy          string
+		projectConf     string
+		expectedResult  keptnv2.ResultType
+		expectedStatus  keptnv2.StatusType
+		expectedMessage string
+	}{
+		{"pass", MissingResourcesPass, "", keptnv2.ResultPass, keptnv2.StatusSucceeded, "Gatling test skipped: "},
+		{"warning", MissingResourcesWarning, "", keptnv2.ResultWarning, keptnv2.StatusSucceeded, "Gatling test skipped: "},
+		{"fail", MissingResourcesFail, "", keptnv2.ResultFailed, keptnv2.StatusSucceeded, "Gatling test failed: "},
+		{"project override", MissingResourcesPass, "missingResources: fail\n", keptnv2.ResultFailed, keptnv2.StatusSucceeded, "Gatling test failed: "},
+		{"invalid project conf", MissingResourcesPass, "missingResources: sometimes\n", keptnv2.ResultFailed, keptnv2.StatusErrored, "Couldn't parse "},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("Content-Type", "application/json")
+				if strings.HasSuffix(r.URL.Path, "/resource/") {
+					_, _ = w.Write([]byte(`{"resources": []}`))
+					return
+				}
+				if testCase.projectConf != "" && r.URL.Path == "/v1/project/pod-tato-head/resource/gatling/gatling.conf.yaml" {
+					uri := "gatling/gatling.conf.yaml"
+					marshal, _ := json.Marshal(&keptnapimodels.Resource{
+						ResourceURI:     &uri,
+						ResourceContent: b64.StdEncoding.EncodeToString([]byte(testCase.projectConf)),
+					})
+					_, _ = w.Write(marshal)
+					return
+				}
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"code": 404, "message": ""}`))
+			}))
+			g, incomingEvent, specificEvent := initializeTestHandlerWithServer(t, ts, "test-events/test.triggered.json")
+			myKeptn := g.myKeptn
+
+			g.executionHandler = func(ctx context.Context, args []string, env []string, output io.Writer) (string, error) {
+				t.Errorf("Unexpected execution call")
+				return "", nil
+			}
+			g.missingResources = testCase.policy
+
+			_ = g.HandleTestTriggeredEvent(*incomingEvent, specificEvent)
+			g.runner.Wait()
+
+			sentEvent := &GatlingTestFinishedEventData{}
+			if err := myKeptn.EventSender.(*fake.EventSender).SentEvents[1].DataAs(sentEvent); err != nil {
+				t.Fatalf("Error getting keptn event data")
+			}
+			if sentEvent.Result != testCase.expectedResult || sentEvent.Status != testCase.expectedStatus {
+				t.Errorf("Expected %s/%s got %s/%s", testCase.expectedStatus, testCase.expectedResult, sentEvent.Status, sentEvent.Result)
+			}
+			if !strings.HasPrefix(sentEvent.Message, testCase.expectedMessage) {
+				t.Errorf("Expected a message starting with %s got %s", testCase.expectedMessage, sentEvent.Message)
+			}
+			if testCase.expectedStatus == keptnv2.StatusSucceeded {
+				if sentEvent.Gatling == nil || sentEvent.Gatling.Skipped == nil || sentEvent.Gatling.Skipped.ResourcePath != "pod-tato-head/hardening/helloservice/gatling/" {
+					t.Errorf("Expected the checked resource path in the details got %+v", sentEvent.Gatling)
+				}
+			}
+		})
+	}
 }
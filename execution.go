@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ExecutionSequential = "sequential"
+	ExecutionParallel   = "parallel"
+
+	SimulationPassed  = "passed"
+	SimulationFailed  = "failed"
+	SimulationErrored = "errored"
+	SimulationSkipped = "skipped"
+	SimulationAborted = "aborted"
+
+	// BinariesDirname folder of GATLING_HOME with the compiled classes of each simulation run in parallel
+	BinariesDirname = "binaries"
+)
+
+// SimulationOutcome outcome of a single simulation in case the workload runs several simulations
+type SimulationOutcome struct {
+	Simulation string               `json:"simulation"`
+	Status     string               `json:"status"`
+	Message    string               `json:"message,omitempty"`
+	Results    *SimulationResults   `json:"results,omitempty"`
+	Assertions []*AssertionResult   `json:"assertions,omitempty"`
+	Runs       []*ServiceURLResults `json:"runs,omitempty"`
+
+	err error
+}
+
+// failed returns whether the simulation failed or couldn't run at all
+func (o *SimulationOutcome) failed() bool {
+	return o.Status == SimulationFailed || o.Status == SimulationErrored
+}
+
+// simulationPlan settings shared by all simulations of a test
+type simulationPlan struct {
+	gatlingHome string
+	resultsDir  string
+	runs        [][]*url.URL
+	testContext *TestContext
+	jvmOptions  []string
+	properties  []string
+}
+
+// resultsDirOf returns the results folder of the simulation, a sub folder in case several simulations are executed
+func (p *simulationPlan) resultsDirOf(simulation string, simulations []string) string {
+	if len(simulations) == 1 {
+		return p.resultsDir
+	}
+	return filepath.Join(p.resultsDir, simulation)
+}
+
+// binariesDirOf returns a binaries folder per simulation, as simulations running in parallel would otherwise compile
+// into the same default folder at the same time
+func (p *simulationPlan) binariesDirOf(simulation string) string {
+	return filepath.Join(p.gatlingHome, BinariesDirname, simulation)
+}
+
+// executeSimulations runs the simulations one after the other or in parallel. With stopOnFailure the first failed
+// simulation skips the remaining ones, respectively aborts the ones still running in parallel.
+func (e *EventHandler) executeSimulations(ctx context.Context, plan *simulationPlan, simulations []string, execution string, stopOnFailure bool) []*SimulationOutcome {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	outcomes := make([]*SimulationOutcome, len(simulations))
+	if execution == ExecutionParallel {
+		var wg sync.WaitGroup
+		for i, simulation := range simulations {
+			wg.Add(1)
+			go func(i int, simulation string) {
+				defer wg.Done()
+				outcomes[i] = e.executeSimulation(ctx, plan, simulation, plan.resultsDirOf(simulation, simulations), plan.binariesDirOf(simulation))
+				if stopOnFailure && outcomes[i].failed() {
+					cancel()
+				}
+			}(i, simulation)
+		}
+		wg.Wait()
+		return outcomes
+	}
+
+	for i, simulation := range simulations {
+		if ctx.Err() != nil {
+			outcomes[i] = &SimulationOutcome{Simulation: simulation, Status: SimulationSkipped}
+			continue
+		}
+		outcomes[i] = e.executeSimulation(ctx, plan, simulation, plan.resultsDirOf(simulation, simulations), "")
+		if stopOnFailure && outcomes[i].failed() {
+			cancel()
+		}
+	}
+	return outcomes
+}
+
+// executeSimulation runs the simulation against the deployment URIs and evaluates the results written into resultsDir.
+// The simulation is compiled into binariesDir unless it's empty, then the default of Gatling is used.
+func (e *EventHandler) executeSimulation(ctx context.Context, plan *simulationPlan, simulation string, resultsDir string, binariesDir string) *SimulationOutcome {
+	outcome := &SimulationOutcome{Simulation: simulation}
+
+	var err error
+	var runResultDirs []string
+	for i, runURLs := range plan.runs {
+		runResultsDir := resultsDir
+		if len(plan.runs) > 1 {
+			runResultsDir = filepath.Join(resultsDir, fmt.Sprintf("uri-%d", i))
+			runResultDirs = append(runResultDirs, runResultsDir)
+		}
+
+		// -> https://github.com/keptn/keptn/blob/069dd0f5c7b6f37a3737f4c0c9c7cf07a801b039/jmeter-service/jmeterUtils.go#L184
+		command := []string{
+			fmt.Sprintf("--simulation=%s", simulation),
+			fmt.Sprintf("--run-description=%s", plan.testContext.RunDescription()),
+			fmt.Sprintf("--results-folder=%s", runResultsDir),
+		}
+		if binariesDir != "" {
+			command = append(command, fmt.Sprintf("--binaries-folder=%s", binariesDir))
+		}
+
+		environment := os.Environ()
+		environment = append(environment, fmt.Sprintf("GATLING_HOME=%s", plan.gatlingHome))
+		environment = append(environment, plan.testContext.Environment()...)
+		// the properties set by the service come last so they can't be overridden by the JVM options or the workload properties
+		javaOptions := append(append([]string{}, plan.jvmOptions...), plan.properties...)
+		javaOptions = append(javaOptions, plan.testContext.JavaProperties()...)
+		javaOptions = append(javaOptions, serviceURLProperties(runURLs)...)
+		environment = append(environment, fmt.Sprintf("JAVA_OPTS=%s", strings.Join(javaOptions, " ")))
+
+		log.Infof("Running gatling simulation %s against %v", simulation, runURLs)
+		str, runErr := e.executionHandler(ctx, command, environment, e.progressReporter())
+		recordGatlingExit(e.metricLabels(), runErr)
+
+		log.Infof("Finished running gatling simulation %s", simulation)
+		log.Infof(str)
+
+		// the first failure decides, but the remaining URIs are still tested
+		if err == nil {
+			err = runErr
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	// assertion failures make gatling.sh exit non-zero, but the test itself ran
+	assertions, assertionErr := loadGatlingAssertions(resultsDir)
+	if assertionErr != nil {
+		log.Warnf("Failed to load Gatling assertions: %s", assertionErr.Error())
+	}
+	outcome.Assertions = assertions
+	failed := failedAssertions(assertions)
+
+	switch {
+	case err != nil && len(failed) == 0 && ctx.Err() == context.Canceled:
+		outcome.Status = SimulationAborted
+		return outcome
+	case err != nil && len(failed) == 0:
+		return outcome.errored(err)
+	}
+
+	outcome.Results, err = loadSimulationResults(resultsDir)
+	if err != nil {
+		return outcome.errored(fmt.Errorf("error parsing gatling results: %s", err.Error()))
+	}
+	for i, runResultsDir := range runResultDirs {
+		runResults, err := loadSimulationResults(runResultsDir)
+		if err != nil {
+			return outcome.errored(fmt.Errorf("error parsing gatling results: %s", err.Error()))
+		}
+		outcome.Runs = append(outcome.Runs, &ServiceURLResults{ServiceURL: plan.runs[i][0].String(), Results: runResults})
+	}
+
+	if len(failed) > 0 {
+		outcome.Status = SimulationFailed
+		outcome.Message = describeAssertions(failed)
+	} else {
+		outcome.Status = SimulationPassed
+	}
+	return outcome
+}
+
+func (o *SimulationOutcome) errored(err error) *SimulationOutcome {
+	o.Status = SimulationErrored
+	o.Message = err.Error()
+	o.err = err
+	return o
+}
@@ -1,52 +1,325 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"github.com/iancoleman/strcase"
 	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
 	"gopkg.in/yaml.v3"
 	"io"
+	"net/url"
 	"os"
 	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
 )
 
 // GatlingConf Configuration file type
 type GatlingConf struct {
-	SpecVersion string      `json:"spec_version" yaml:"spec_version"`
-	Workloads   []*Workload `json:"workloads" yaml:"workloads"`
+	SpecVersion      string       `json:"spec_version" yaml:"spec_version"`
+	MissingResources string       `json:"missingResources,omitempty" yaml:"missingResources,omitempty"`
+	Reports          *ReportsConf `json:"reports,omitempty" yaml:"reports,omitempty"`
+	Workloads        []*Workload  `json:"workloads" yaml:"workloads"`
+}
+
+const (
+	MissingResourcesPass    = "pass"
+	MissingResourcesWarning = "warning"
+	MissingResourcesFail    = "fail"
+)
+
+// validateMissingResourcesPolicy checks the result reported in case no Gatling resources are found
+func validateMissingResourcesPolicy(policy string) error {
+	switch policy {
+	case MissingResourcesPass, MissingResourcesWarning, MissingResourcesFail:
+		return nil
+	}
+	return fmt.Errorf("unknown missing resources policy %s, expected one of %s, %s or %s", policy, MissingResourcesPass, MissingResourcesWarning, MissingResourcesFail)
+}
+
+// ReportsConf upload of the generated HTML reports into the Keptn configuration repository
+type ReportsConf struct {
+	Upload string `json:"upload" yaml:"upload"`
 }
 
 // Workload of Keptn stage
 type Workload struct {
-	TestStrategy string `json:"teststrategy" yaml:"teststrategy"`
-	Simulation   string `json:"simulation" yaml:"simulation"`
+	TestStrategy       string            `json:"teststrategy" yaml:"teststrategy"`
+	Stage              string            `json:"stage,omitempty" yaml:"stage,omitempty"`
+	DeploymentStrategy string            `json:"deploymentstrategy,omitempty" yaml:"deploymentstrategy,omitempty"`
+	DeploymentNames    []string          `json:"deploymentNames,omitempty" yaml:"deploymentNames,omitempty"`
+	Simulation         string            `json:"simulation" yaml:"simulation"`
+	Timeout            string            `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	Properties         map[string]string `json:"properties,omitempty" yaml:"properties,omitempty"`
+	JVMOptions         []string          `json:"jvmOptions,omitempty" yaml:"jvmOptions,omitempty"`
+	DeploymentURIs     string            `json:"deploymentURIs,omitempty" yaml:"deploymentURIs,omitempty"`
+	RunPerURI          bool              `json:"runPerURI,omitempty" yaml:"runPerURI,omitempty"`
+	Simulations        []string          `json:"simulations,omitempty" yaml:"simulations,omitempty"`
+	Execution          string            `json:"execution,omitempty" yaml:"execution,omitempty"`
+	StopOnFailure      bool              `json:"stopOnFailure,omitempty" yaml:"stopOnFailure,omitempty"`
+	Assertions         []*Assertion      `json:"assertions,omitempty" yaml:"assertions,omitempty"`
+}
+
+// Assertion criteria evaluated by the service against the parsed results
+type Assertion struct {
+	Metric   string   `json:"metric" yaml:"metric"`
+	Request  string   `json:"request,omitempty" yaml:"request,omitempty"`
+	Max      *float64 `json:"max,omitempty" yaml:"max,omitempty"`
+	Min      *float64 `json:"min,omitempty" yaml:"min,omitempty"`
+	Severity string   `json:"severity,omitempty" yaml:"severity,omitempty"`
 }
 
-// parseGatlingConf parses config file content and maps it to the GatlingConf struct
+// parseGatlingConf parses config file content and maps it to the GatlingConf struct, unknown keys are rejected
 func parseGatlingConf(input []byte) (*GatlingConf, error) {
 	gatlingconf := &GatlingConf{}
 
-	err := yaml.Unmarshal(input, &gatlingconf)
-	if err != nil {
+	decoder := yaml.NewDecoder(bytes.NewReader(input))
+	decoder.KnownFields(true)
+	err := decoder.Decode(gatlingconf)
+	if err != nil && err != io.EOF {
 		return nil, err
 	}
 	return gatlingconf, nil
 }
 
-// determineSimulationName maps the TestStrategy to a simulation name
-func determineSimulationName(data *keptnv2.TestTriggeredEventData, conf *GatlingConf) string {
-	var simulation = fmt.Sprintf("%sSimulation", strcase.ToCamel(data.Test.TestStrategy))
-	if conf != nil {
-		for _, workload := range conf.Workloads {
-			if workload.TestStrategy == data.Test.TestStrategy {
-				if workload.Simulation != "" {
-					simulation = workload.Simulation
-					break
-				}
+// determineSimulationName derives the simulation name from the TestStrategy, used unless the workload configures simulations
+func determineSimulationName(data *keptnv2.TestTriggeredEventData) string {
+	return fmt.Sprintf("%sSimulation", strcase.ToCamel(data.Test.TestStrategy))
+}
+
+// determineSimulations returns the simulations of the test, the simulation of the workload is followed by its list of simulations.
+// Without any simulation configured, the simulation name is derived from the TestStrategy.
+func determineSimulations(data *keptnv2.TestTriggeredEventData, workload *Workload) ([]string, error) {
+	var simulations []string
+	if workload != nil {
+		if workload.Simulation != "" {
+			simulations = append(simulations, workload.Simulation)
+		}
+		simulations = append(simulations, workload.Simulations...)
+	}
+	if len(simulations) == 0 {
+		return []string{determineSimulationName(data)}, nil
+	}
+
+	seen := map[string]bool{}
+	for _, simulation := range simulations {
+		if simulation == "" || seen[simulation] {
+			return nil, fmt.Errorf("invalid simulations %v for teststrategy %s: names must be unique and non-empty", simulations, workload.TestStrategy)
+		}
+		seen[simulation] = true
+	}
+	return simulations, nil
+}
+
+// determineExecution returns whether the simulations of the workload are executed sequential or parallel
+func determineExecution(workload *Workload) (string, error) {
+	if workload == nil || workload.Execution == "" {
+		return ExecutionSequential, nil
+	}
+	if workload.Execution != ExecutionSequential && workload.Execution != ExecutionParallel {
+		return "", fmt.Errorf("invalid execution %s for teststrategy %s, expected %s or %s", workload.Execution, workload.TestStrategy, ExecutionSequential, ExecutionParallel)
+	}
+	return workload.Execution, nil
+}
+
+// findWorkload returns the most specific workload matching the TestStrategy and the optional selectors of the test.
+// In case several workloads are equally specific, the first one wins.
+func findWorkload(conf *GatlingConf, testContext *TestContext) *Workload {
+	if conf == nil {
+		return nil
+	}
+	var selected *Workload
+	for _, workload := range conf.Workloads {
+		if workload.matches(testContext) && (selected == nil || workload.specificity() > selected.specificity()) {
+			selected = workload
+		}
+	}
+	return selected
+}
+
+// matches returns whether the TestStrategy and all selectors of the workload match the test
+func (w *Workload) matches(testContext *TestContext) bool {
+	if w.TestStrategy != testContext.TestStrategy {
+		return false
+	}
+	if w.Stage != "" && w.Stage != testContext.Stage {
+		return false
+	}
+	if w.DeploymentStrategy != "" && w.DeploymentStrategy != testContext.DeploymentStrategy {
+		return false
+	}
+	if len(w.DeploymentNames) == 0 {
+		return true
+	}
+	for _, name := range w.DeploymentNames {
+		for _, deploymentName := range testContext.DeploymentNames {
+			if name == deploymentName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// specificity ranks the selectors of the workload: stage before deploymentstrategy before deploymentNames
+func (w *Workload) specificity() int {
+	specificity := 0
+	if w.Stage != "" {
+		specificity += 4
+	}
+	if w.DeploymentStrategy != "" {
+		specificity += 2
+	}
+	if len(w.DeploymentNames) > 0 {
+		specificity++
+	}
+	return specificity
+}
+
+// determineTimeout returns the timeout of the workload or the given default
+func determineTimeout(workload *Workload, defaultTimeout time.Duration) (time.Duration, error) {
+	if workload == nil || workload.Timeout == "" {
+		return defaultTimeout, nil
+	}
+	timeout, err := time.ParseDuration(workload.Timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout %s for teststrategy %s: %s", workload.Timeout, workload.TestStrategy, err.Error())
+	}
+	return timeout, nil
+}
+
+var javaPropertyNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.\-]+$`)
+
+// buildJavaProperties renders the workload properties as -D system properties, values are templates
+// with access to the TestContext, e.g. "{{.Stage}}"
+func buildJavaProperties(workload *Workload, testContext *TestContext) ([]string, error) {
+	if workload == nil || len(workload.Properties) == 0 {
+		return nil, nil
+	}
+
+	names := make([]string, 0, len(workload.Properties))
+	for name := range workload.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	properties := make([]string, 0, len(names))
+	for _, name := range names {
+		if !javaPropertyNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid property name %q", name)
+		}
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(workload.Properties[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of property %s: %s", name, err.Error())
+		}
+		value := &strings.Builder{}
+		if err = tmpl.Execute(value, testContext); err != nil {
+			return nil, fmt.Errorf("error rendering property %s: %s", name, err.Error())
+		}
+		// JAVA_OPTS is split at whitespace by gatling.sh
+		if strings.ContainsAny(value.String(), " \t\n") {
+			return nil, fmt.Errorf("value of property %s must not contain whitespace: %q", name, value.String())
+		}
+		properties = append(properties, fmt.Sprintf("-D%s=%s", name, value.String()))
+	}
+	return properties, nil
+}
+
+// serviceURLProperties passes the first URL as serviceURL, all URLs as comma separated serviceURLs
+// and additionally indexed as serviceURLs.0, serviceURLs.1, ...
+func serviceURLProperties(serviceURLs []*url.URL) []string {
+	uris := make([]string, 0, len(serviceURLs))
+	for _, serviceURL := range serviceURLs {
+		uris = append(uris, serviceURL.String())
+	}
+
+	properties := []string{
+		fmt.Sprintf("-DserviceURL=%s", uris[0]),
+		fmt.Sprintf("-DserviceURLs=%s", strings.Join(uris, ",")),
+	}
+	for i, uri := range uris {
+		properties = append(properties, fmt.Sprintf("-DserviceURLs.%d=%s", i, uri))
+	}
+	return properties
+}
+
+var jvmHeapOptionPattern = regexp.MustCompile(`^-Xm[sx][0-9]+[kKmMgG]?$`)
+
+// allowedJVMOptions prefixes of the options which can be passed to the JVM running Gatling
+var allowedJVMOptions = []string{"-X", "-D", "-verbose", "-ea", "-da", "-server"}
+
+// forbiddenJVMOptions prefixes of the options which load foreign code, execute commands, replace the Gatling classpath
+// or write files to arbitrary paths
+var forbiddenJVMOptions = []string{
+	"-Xbootclasspath", "-Xrun", "-Xdebug", "-XX:OnError", "-XX:OnOutOfMemoryError", "-Djava.security.manager", "-Djava.security.policy",
+	"-Xloggc", "-XX:HeapDumpPath", "-XX:ErrorFile", "-XX:LogFile",
+}
+
+// jvmLogOutputs outputs of -Xlog options which don't write files
+var jvmLogOutputs = []string{"", "stdout", "stderr"}
+
+// validateJVMOptions rejects malformed options and options which are not safe to pass to the shared service
+func validateJVMOptions(options []string) error {
+	for _, option := range options {
+		if option == "" || strings.ContainsAny(option, " \t\n;&|$`'\"<>\\") {
+			return fmt.Errorf("invalid JVM option %q", option)
+		}
+		if !hasAnyPrefix(option, allowedJVMOptions) || hasAnyPrefix(option, forbiddenJVMOptions) {
+			return fmt.Errorf("JVM option %s is not allowed", option)
+		}
+		if strings.HasPrefix(option, "-Xlog") {
+			// -Xlog[:what[:output[:decorators[:output-options]]]]
+			parts := strings.Split(strings.TrimPrefix(option, "-Xlog"), ":")
+			if len(parts) > 2 && !containsString(jvmLogOutputs, parts[2]) {
+				return fmt.Errorf("JVM option %s is not allowed, logs can only be written to stdout or stderr", option)
+			}
+		}
+		if strings.HasPrefix(option, "-Xms") || strings.HasPrefix(option, "-Xmx") {
+			if !jvmHeapOptionPattern.MatchString(option) {
+				return fmt.Errorf("invalid heap size %s", option)
+			}
+		}
+		if strings.HasPrefix(option, "-D") {
+			name := strings.SplitN(strings.TrimPrefix(option, "-D"), "=", 2)[0]
+			if !javaPropertyNamePattern.MatchString(name) {
+				return fmt.Errorf("invalid property name in JVM option %s", option)
 			}
 		}
 	}
-	return simulation
+	return nil
+}
+
+// buildJVMOptions appends the JVM options of the workload to the defaults of the service, later options take precedence
+func buildJVMOptions(defaults []string, workload *Workload) ([]string, error) {
+	options := append([]string{}, defaults...)
+	if workload != nil {
+		options = append(options, workload.JVMOptions...)
+	}
+	if err := validateJVMOptions(options); err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultConfFiles the files of the default Gatling installation used unless they're part of the resource files
+var defaultConfFiles = []string{"logback.xml", "gatling.conf", "gatling-akka.conf"}
+
+// defaultConfFile returns the path of the file within the default Gatling installation below rootDir
+func defaultConfFile(rootDir, file string) string {
+	return path.Join([]string{rootDir, "opt", "gatling", "conf", file}...)
 }
 
 // restoreDefaultConfFiles will copy the default gatling config files to the temp directory
@@ -57,8 +330,8 @@ func restoreDefaultConfFiles(rootDir, tempDir string) error {
 	if err != nil {
 		return err
 	}
-	for _, file := range []string{"logback.xml", "gatling.conf", "gatling-akka.conf"} {
-		sourceConfFile := path.Join([]string{rootDir, "opt", "gatling", "conf", file}...)
+	for _, file := range defaultConfFiles {
+		sourceConfFile := defaultConfFile(rootDir, file)
 		targetConfFile := path.Join([]string{targetConf, file}...)
 
 		if _, err := os.Stat(targetConfFile); err == nil {
@@ -85,8 +358,11 @@ func restoreDefaultConfFiles(rootDir, tempDir string) error {
 	return nil
 }
 
-type GatlingExecutionHandler func(args []string, env []string) (string, error)
+// GatlingCommand the script starting Gatling, expected on the PATH
+const GatlingCommand = "gatling.sh"
+
+type GatlingExecutionHandler func(ctx context.Context, args []string, env []string, output io.Writer) (string, error)
 
-func ScriptGatlingExecutionHandler(args []string, env []string) (string, error) {
-	return ExecuteCommandWithEnv("gatling.sh", args, env)
+func ScriptGatlingExecutionHandler(ctx context.Context, args []string, env []string, output io.Writer) (string, error) {
+	return ExecuteCommandWithEnv(ctx, GatlingCommand, args, env, output)
 }
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+)
+
+const (
+	HealthPath    = "/health"
+	ReadinessPath = "/ready"
+)
+
+// ReadinessCheck a named precondition of handling test.triggered events
+type ReadinessCheck struct {
+	Name  string
+	Check func() error
+}
+
+// CheckResult outcome of a single readiness check
+type CheckResult struct {
+	Name    string `json:"name"`
+	Ready   bool   `json:"ready"`
+	Message string `json:"message,omitempty"`
+}
+
+// ReadinessStatus body of the readiness endpoint
+type ReadinessStatus struct {
+	Ready  bool           `json:"ready"`
+	Checks []*CheckResult `json:"checks"`
+}
+
+// readinessChecks returns the checks of the Gatling installation, the configuration service and the test runner
+func readinessChecks(confDirRoot, tempPathPrefix, configurationServiceURL string, runner *TestRunner) []*ReadinessCheck {
+	checks := []*ReadinessCheck{
+		{Name: "gatling", Check: func() error {
+			_, err := exec.LookPath(GatlingCommand)
+			return err
+		}},
+		{Name: "conf", Check: func() error {
+			for _, file := range defaultConfFiles {
+				if _, err := os.Stat(defaultConfFile(confDirRoot, file)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+		{Name: "tempdir", Check: func() error {
+			tempDir, err := ioutil.TempDir(tempPathPrefix, ResourcePrefix)
+			if err != nil {
+				return err
+			}
+			return os.RemoveAll(tempDir)
+		}},
+		{Name: "runner", Check: func() error {
+			if runner.Closed() {
+				return fmt.Errorf("service is shutting down")
+			}
+			if runner.Saturated() {
+				running, queued := runner.Stats()
+				return fmt.Errorf("test queue is full (%d test runs running, %d waiting)", running, queued)
+			}
+			return nil
+		}},
+	}
+	if configurationServiceURL != "" {
+		client := &http.Client{Timeout: 5 * time.Second}
+		checks = append(checks, &ReadinessCheck{Name: "configuration-service", Check: func() error {
+			resp, err := client.Get(configurationServiceURL)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			// any response proves the service is reachable, but it's not working properly on server errors
+			if resp.StatusCode >= 500 {
+				return fmt.Errorf("configuration service responded with %s", resp.Status)
+			}
+			return nil
+		}})
+	}
+	return checks
+}
+
+// healthHandler answers liveness probes as long as the service is able to handle requests
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(`{"status": "OK"}`))
+}
+
+// readinessHandler runs the checks on each request and responds with 503 in case any of them fails
+func readinessHandler(checks []*ReadinessCheck) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := &ReadinessStatus{Ready: true}
+		for _, check := range checks {
+			result := &CheckResult{Name: check.Name, Ready: true}
+			if err := check.Check(); err != nil {
+				result.Ready = false
+				result.Message = err.Error()
+				status.Ready = false
+			}
+			status.Checks = append(status.Checks, result)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if !status.Ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(status)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadinessHandler(t *testing.T) {
+	// a fake gatling.sh on the PATH
+	binDir, err := ioutil.TempDir("./test-tmp/", "bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(binDir)
+	if err = ioutil.WriteFile(filepath.Join(binDir, GatlingCommand), []byte("#!/bin/sh\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	absoluteBinDir, _ := filepath.Abs(binDir)
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", absoluteBinDir)
+
+	configurationService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer configurationService.Close()
+	failingService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failingService.Close()
+
+	tests := []struct {
+		name                    string
+		confDirRoot             string
+		tempPathPrefix          string
+		configurationServiceURL string
+		saturated               bool
+		expectedStatus          int
+		expectedFailed          []string
+	}{
+		{"ready", "test-data/dist", "./test-tmp/", configurationService.URL, false, http.StatusOK, nil},
+		{"without configuration service", "test-data/dist", "./test-tmp/", "", false, http.StatusOK, nil},
+		{"missing conf files", "test-data/simple", "./test-tmp/", "", false, http.StatusServiceUnavailable, []string{"conf"}},
+		{"temp dir not writable", "test-data/dist", "./test-tmp/missing/", "", false, http.StatusServiceUnavailable, []string{"tempdir"}},
+		{"configuration service failing", "test-data/dist", "./test-tmp/", failingService.URL, false, http.StatusServiceUnavailable, []string{"configuration-service"}},
+		{"configuration service unreachable", "test-data/dist", "./test-tmp/", "http://127.0.0.1:1", false, http.StatusServiceUnavailable, []string{"configuration-service"}},
+		{"queue full", "test-data/dist", "./test-tmp/", "", true, http.StatusServiceUnavailable, []string{"runner"}},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			runner := NewTestRunner(1, 0)
+			block := make(chan struct{})
+			if testCase.saturated {
+				_ = runner.Submit(&TestRun{ID: "blocking", Project: "health", Execute: func() error {
+					<-block
+					return nil
+				}})
+			}
+			defer runner.Wait()
+			defer close(block)
+
+			checks := readinessChecks(testCase.confDirRoot, testCase.tempPathPrefix, testCase.configurationServiceURL, runner)
+			recorder := httptest.NewRecorder()
+			readinessHandler(checks).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, ReadinessPath, nil))
+
+			if recorder.Code != testCase.expectedStatus {
+				t.Errorf("Expected status %d got %d: %s", testCase.expectedStatus, recorder.Code, recorder.Body.String())
+			}
+			status := &ReadinessStatus{}
+			if err := json.NewDecoder(recorder.Body).Decode(status); err != nil {
+				t.Fatal(err)
+			}
+			var failed []string
+			for _, check := range status.Checks {
+				if !check.Ready {
+					failed = append(failed, check.Name)
+				}
+			}
+			if len(failed) != len(testCase.expectedFailed) || (len(failed) > 0 && failed[0] != testCase.expectedFailed[0]) {
+				t.Errorf("Expected failed checks %v got %v", testCase.expectedFailed, failed)
+			}
+		})
+	}
+}
+
+func TestReadinessHandlerWithoutGatling(t *testing.T) {
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", "")
+
+	checks := readinessChecks("test-data/dist", "./test-tmp/", "", NewTestRunner(1, 1))
+	recorder := httptest.NewRecorder()
+	readinessHandler(checks).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, ReadinessPath, nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected the service not to be ready without %s got %d", GatlingCommand, recorder.Code)
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	healthHandler(recorder, httptest.NewRequest(http.MethodGet, HealthPath, nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected status 200 got %d", recorder.Code)
+	}
+}
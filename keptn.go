@@ -1,41 +1,70 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	keptnapimodels "github.com/keptn/go-utils/pkg/api/models"
+	keptnapi "github.com/keptn/go-utils/pkg/api/utils"
 	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
 	log "github.com/sirupsen/logrus"
+	"io"
 	"net/url"
 	"os"
 	"os/exec"
 	"path"
+	"regexp"
 	"strings"
+	"syscall"
 )
 
-// getGatlingConf loads gatling.conf.yaml for the current service
+// getGatlingConf loads gatling.conf.yaml of the project, the stage and the service and merges them,
+// the service level overrides the stage level, which overrides the project level
 func getGatlingConf(myKeptn *keptnv2.Keptn, project string, stage string, service string) (*GatlingConf, error) {
-	var err error
-
 	confFile := path.Join(ResourcePrefix, ConfFilename)
 	log.Infof("Loading %s for %s.%s.%s", confFile, project, stage, service)
 
-	keptnResourceContent, err := myKeptn.GetKeptnResource(confFile)
+	levels := []struct {
+		name string
+		get  func() (*keptnapimodels.Resource, error)
+	}{
+		{fmt.Sprintf("project %s", project), func() (*keptnapimodels.Resource, error) {
+			return myKeptn.ResourceHandler.GetProjectResource(project, confFile)
+		}},
+		{fmt.Sprintf("stage %s", stage), func() (*keptnapimodels.Resource, error) {
+			return myKeptn.ResourceHandler.GetStageResource(project, stage, confFile)
+		}},
+		{fmt.Sprintf("service %s", service), func() (*keptnapimodels.Resource, error) {
+			return myKeptn.ResourceHandler.GetServiceResource(project, stage, service, confFile)
+		}},
+	}
 
-	if err != nil {
-		logMessage := fmt.Sprintf("error when trying to load %s file for service %s on stage %s or project-level %s: %s", confFile, service, stage, project, err.Error())
-		return nil, errors.New(logMessage)
+	var layers []*ConfLayer
+	for _, level := range levels {
+		resource, err := level.get()
+		if err == keptnapi.ResourceNotFoundError {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error when trying to load %s file on %s: %s", confFile, level.name, err.Error())
+		}
+		if resource.ResourceContent != "" {
+			log.Infof("Found %s on %s", confFile, level.name)
+			layers = append(layers, &ConfLayer{Name: level.name, Content: []byte(resource.ResourceContent)})
+		}
 	}
-	if len(keptnResourceContent) == 0 {
+
+	if len(layers) == 0 {
 		// if no configuration file is available, this is not an error, as the service will proceed with the default workload
 		log.Warnf("no %s found", confFile)
 		return nil, nil
 	}
 
-	var gatlingConf *GatlingConf
-	gatlingConf, err = parseGatlingConf(keptnResourceContent)
+	gatlingConf, err := mergeGatlingConf(layers)
 	if err != nil {
-		logMessage := fmt.Sprintf("Couldn't parse %s file found for service %s in stage %s in project %s. Error: %s", confFile, service, stage, project, err.Error())
-		return nil, errors.New(logMessage)
+		return nil, fmt.Errorf("Couldn't parse %s file found for service %s in stage %s in project %s. Error: %w", confFile, service, stage, project, err)
 	}
 
 	log.Infof("Successfully loaded %s with %d workloads", ConfFilename, len(gatlingConf.Workloads))
@@ -54,7 +83,7 @@ func getAllGatlingResources(myKeptn *keptnv2.Keptn, project string, stage string
 
 	downloaded := 0
 	for _, resource := range resources {
-		if strings.Contains(*resource.ResourceURI, "gatling/") {
+		if strings.Contains(*resource.ResourceURI, "gatling/") && !strings.Contains(*resource.ResourceURI, ReportsResourcePrefix) {
 			log.Infof("Found file: %s", *resource.ResourceURI)
 			_, err := getKeptnResource(myKeptn, *resource.ResourceURI, tempDir)
 
@@ -99,27 +128,217 @@ func getKeptnResource(myKeptn *keptnv2.Keptn, resourceName string, tempDir strin
 	return targetFileName, nil
 }
 
+// TestContext details of the test.triggered event which are available to simulations
+type TestContext struct {
+	KeptnContext       string
+	Project            string
+	Stage              string
+	Service            string
+	TestStrategy       string
+	DeploymentStrategy string
+	DeploymentNames    []string
+	GitCommit          string
+	Image              string
+	EventID            string
+}
+
+// testTriggeredDeploymentDetails fields of the test.triggered event which aren't covered by go-utils
+type testTriggeredDeploymentDetails struct {
+	Deployment struct {
+		DeploymentStrategy string   `json:"deploymentstrategy"`
+		DeploymentNames    []string `json:"deploymentNames"`
+		GitCommit          string   `json:"gitCommit"`
+	} `json:"deployment"`
+	ConfigurationChange struct {
+		Values struct {
+			Image string `json:"image"`
+		} `json:"values"`
+	} `json:"configurationChange"`
+}
+
+var runIDInvalidCharacters = regexp.MustCompile(`[^A-Za-z0-9_.\-]`)
+
+// RunID returns the name of the results folder of the test, derived from the Keptn context and the event ID
+func (c *TestContext) RunID() string {
+	return runIDInvalidCharacters.ReplaceAllString(c.KeptnContext+"-"+c.EventID, "_")
+}
+
+// RunDescription returns the description of the Gatling run, shown in the HTML report
+func (c *TestContext) RunDescription() string {
+	return fmt.Sprintf("Keptn %s/%s/%s %s (context %s, event %s)", c.Project, c.Stage, c.Service, c.TestStrategy, c.KeptnContext, c.EventID)
+}
+
+// contextVariable a detail of the TestContext passed to the simulation as Java system property and environment variable
+type contextVariable struct {
+	property    string
+	environment string
+	value       string
+}
+
+func (c *TestContext) variables() []contextVariable {
+	return []contextVariable{
+		{"keptn.project", "KEPTN_PROJECT", c.Project},
+		{"keptn.stage", "KEPTN_STAGE", c.Stage},
+		{"keptn.service", "KEPTN_SERVICE", c.Service},
+		{"keptn.context", "KEPTN_CONTEXT", c.KeptnContext},
+		{"keptn.eventId", "KEPTN_EVENT_ID", c.EventID},
+		{"keptn.testStrategy", "KEPTN_TEST_STRATEGY", c.TestStrategy},
+		{"keptn.deploymentStrategy", "KEPTN_DEPLOYMENT_STRATEGY", c.DeploymentStrategy},
+		{"keptn.gitCommit", "KEPTN_GIT_COMMIT", c.GitCommit},
+		{"keptn.image", "KEPTN_IMAGE", c.Image},
+	}
+}
+
+// JavaProperties returns the non-empty details as keptn.* system properties
+func (c *TestContext) JavaProperties() []string {
+	var properties []string
+	for _, variable := range c.variables() {
+		if variable.value == "" {
+			continue
+		}
+		// JAVA_OPTS is split at whitespace by gatling.sh, the value is still available as environment variable
+		if strings.ContainsAny(variable.value, " \t\n") {
+			log.Warnf("Not passing %s as system property as it contains whitespace", variable.property)
+			continue
+		}
+		properties = append(properties, fmt.Sprintf("-D%s=%s", variable.property, variable.value))
+	}
+	return properties
+}
+
+// Environment returns the non-empty details as KEPTN_* environment variables
+func (c *TestContext) Environment() []string {
+	var environment []string
+	for _, variable := range c.variables() {
+		if variable.value != "" {
+			environment = append(environment, fmt.Sprintf("%s=%s", variable.environment, variable.value))
+		}
+	}
+	return environment
+}
+
+// getTestContext collects the details of the test.triggered event
+func getTestContext(myKeptn *keptnv2.Keptn, incomingEvent cloudevents.Event, data *keptnv2.TestTriggeredEventData) (*TestContext, error) {
+	details := &testTriggeredDeploymentDetails{}
+	if err := incomingEvent.DataAs(details); err != nil {
+		return nil, err
+	}
+
+	return &TestContext{
+		KeptnContext:       myKeptn.KeptnContext,
+		Project:            myKeptn.Event.GetProject(),
+		Stage:              myKeptn.Event.GetStage(),
+		Service:            myKeptn.Event.GetService(),
+		TestStrategy:       data.Test.TestStrategy,
+		DeploymentStrategy: details.Deployment.DeploymentStrategy,
+		DeploymentNames:    details.Deployment.DeploymentNames,
+		GitCommit:          details.Deployment.GitCommit,
+		Image:              details.ConfigurationChange.Values.Image,
+		EventID:            incomingEvent.ID(),
+	}, nil
+}
+
+const (
+	// DeploymentURIsAuto prefers the local deployment URIs and falls back to the public ones
+	DeploymentURIsAuto   = ""
+	DeploymentURIsLocal  = "local"
+	DeploymentURIsPublic = "public"
+)
+
 // getServiceURL returns the service URL that is either passed via the DeploymentURI* parameters or constructs one based on keptn naming structure
 func getServiceURL(data *keptnv2.TestTriggeredEventData) (*url.URL, error) {
-	if len(data.Deployment.DeploymentURIsLocal) > 0 && data.Deployment.DeploymentURIsLocal[0] != "" {
-		return url.Parse(data.Deployment.DeploymentURIsLocal[0])
+	serviceURLs, err := getServiceURLs(data, DeploymentURIsAuto)
+	if err != nil {
+		return nil, err
+	}
+	return serviceURLs[0], nil
+}
+
+// getServiceURLs returns all local or public deployment URIs of the event depending on the preference
+func getServiceURLs(data *keptnv2.TestTriggeredEventData, preference string) ([]*url.URL, error) {
+	var uris []string
+	switch preference {
+	case DeploymentURIsAuto:
+		uris = nonEmpty(data.Deployment.DeploymentURIsLocal)
+		if len(uris) == 0 {
+			uris = nonEmpty(data.Deployment.DeploymentURIsPublic)
+		}
+	case DeploymentURIsLocal:
+		uris = nonEmpty(data.Deployment.DeploymentURIsLocal)
+	case DeploymentURIsPublic:
+		uris = nonEmpty(data.Deployment.DeploymentURIsPublic)
+	default:
+		return nil, fmt.Errorf("unknown deployment URI preference %s", preference)
+	}
+
+	if len(uris) == 0 {
+		if preference == DeploymentURIsAuto {
+			return nil, errors.New("no deployment URI included in event")
+		}
+		return nil, fmt.Errorf("no %s deployment URI included in event", preference)
+	}
 
-	} else if len(data.Deployment.DeploymentURIsPublic) > 0 && data.Deployment.DeploymentURIsPublic[0] != "" {
-		return url.Parse(data.Deployment.DeploymentURIsPublic[0])
+	serviceURLs := make([]*url.URL, 0, len(uris))
+	for _, uri := range uris {
+		serviceURL, err := url.Parse(uri)
+		if err != nil {
+			return nil, fmt.Errorf("invalid deployment URI %s: %s", uri, err.Error())
+		}
+		serviceURLs = append(serviceURLs, serviceURL)
 	}
+	return serviceURLs, nil
+}
 
-	return nil, errors.New("no deployment URI included in event")
+func nonEmpty(values []string) []string {
+	var result []string
+	for _, value := range values {
+		if value != "" {
+			result = append(result, value)
+		}
+	}
+	return result
 }
 
 // borrowed from go-utils, remove when https://github.com/keptn/go-utils/pull/286 is merged and new go-utils version available
-func ExecuteCommandWithEnv(command string, args []string, env []string) (string, error) {
+// The command runs in its own process group, which is killed entirely once the context is done.
+// The combined output is additionally streamed to output unless it's nil.
+func ExecuteCommandWithEnv(ctx context.Context, command string, args []string, env []string, output io.Writer) (string, error) {
 	cmd := exec.Command(command, args...)
 	cmd.Env = append(cmd.Env, env...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 	log.Debugf("executing command %s %s", command, strings.Join(args, " "))
 
-	out, err := cmd.CombinedOutput()
+	var out bytes.Buffer
+	var writer io.Writer = &out
+	if output != nil {
+		writer = io.MultiWriter(&out, output)
+	}
+	cmd.Stdout = writer
+	cmd.Stderr = writer
+
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("Error executing command %s %s: %s", command, strings.Join(args, " "), err.Error())
+	}
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			// gatling.sh forks the JVM, so the whole process group needs to be stopped
+			log.Warnf("Killing command %s: %s", command, ctx.Err().Error())
+			_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		case <-done:
+		}
+	}()
+
+	err := cmd.Wait()
+	close(done)
+
+	if ctx.Err() != nil {
+		return out.String(), fmt.Errorf("Error executing command %s %s: %w\n%s", command, strings.Join(args, " "), ctx.Err(), out.String())
+	}
 	if err != nil {
-		return "", fmt.Errorf("Error executing command %s %s: %s\n%s", command, strings.Join(args, " "), err.Error(), string(out))
+		return out.String(), fmt.Errorf("Error executing command %s %s: %w\n%s", command, strings.Join(args, " "), err, out.String())
 	}
-	return string(out), nil
+	return out.String(), nil
 }
@@ -5,16 +5,51 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2" // make sure to use v2 cloudevents here
 	"github.com/kelseyhightower/envconfig"
 	keptn "github.com/keptn/go-utils/pkg/lib/keptn"
 	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var keptnOptions = keptn.KeptnOpts{}
 
+// results of the latest test runs, used to answer get-sli.triggered events. They're kept in memory only,
+// thus lost on restart and not shared between replicas.
+var resultStore = NewResultStore(DefaultResultStoreSize)
+
+// statistics of the latest run of each simulation, exposed as metrics
+var lastRunMetrics = NewLastRunCollector()
+
+// executes the test runs in the background
+var testRunner *TestRunner
+
+// timeout of test runs unless configured by the workload
+var defaultTestTimeout time.Duration
+
+// interval of test.status.changed events
+var statusUpdateInterval time.Duration
+
+// JVM options of all test runs, extended by the workload
+var defaultJVMOptions []string
+
+// result of tests without Gatling resources unless overridden by the project
+var missingResourcesPolicy string
+
+// remembers handled test.triggered events to detect redeliveries, nil if disabled
+var dedupStore DedupStore
+
+// persists the artifacts of each test run, nil if disabled
+var artifactStore ArtifactStore
+
 type envConfig struct {
 	// Port on which to listen for cloudevents
 	Port int `envconfig:"RCV_PORT" default:"8080"`
@@ -24,8 +59,47 @@ type envConfig struct {
 	Env string `envconfig:"ENV" default:"local"`
 	// URL of the Keptn configuration service (this is where we can fetch files from the config repo)
 	ConfigurationServiceUrl string `envconfig:"CONFIGURATION_SERVICE" default:""`
+	// Maximum number of Gatling tests executed in parallel
+	MaxConcurrentTests int `envconfig:"MAX_CONCURRENT_TESTS" default:"1"`
+	// Maximum number of tests waiting for execution, further test.triggered events are rejected
+	MaxQueuedTests int `envconfig:"MAX_QUEUED_TESTS" default:"20"`
+	// Default timeout of a Gatling test, 0 disables the timeout
+	DefaultTestTimeout time.Duration `envconfig:"DEFAULT_TEST_TIMEOUT" default:"0"`
+	// Minimum interval between test.status.changed events during a test, 0 disables them
+	StatusUpdateInterval time.Duration `envconfig:"STATUS_UPDATE_INTERVAL" default:"30s"`
+	// Default JVM options of Gatling, e.g. "-Xmx2G -XX:+UseG1GC"
+	JVMOptions string `envconfig:"JVM_OPTIONS" default:""`
+	// Result of tests without Gatling resources, either "pass", "warning" or "fail"
+	MissingResourcesPolicy string `envconfig:"MISSING_RESOURCES_POLICY" default:"pass"`
+	// Time to wait for running tests on shutdown before they're aborted and reported as errored
+	ShutdownDrainPeriod time.Duration `envconfig:"SHUTDOWN_DRAIN_PERIOD" default:"25s"`
+	// Store of handled test.triggered events to detect redeliveries, either "memory", "file" or "none"
+	DedupStore string `envconfig:"DEDUP_STORE" default:"memory"`
+	// File of the file deduplication store, e.g. on a mounted volume
+	DedupStoreFile string `envconfig:"DEDUP_STORE_FILE" default:""`
+	// Time handled test.triggered events are remembered
+	DedupTTL time.Duration `envconfig:"DEDUP_TTL" default:"24h"`
+	// Store for the artifacts of each test run, either empty (disabled), "filesystem" or "s3"
+	ArtifactStore string `envconfig:"ARTIFACT_STORE" default:""`
+	// Directory of the filesystem artifact store
+	ArtifactStoreDir string `envconfig:"ARTIFACT_STORE_DIR" default:""`
+	// Endpoint URL of the S3 compatible artifact store, e.g. http://minio:9000
+	S3Endpoint string `envconfig:"S3_ENDPOINT" default:""`
+	// Bucket of the S3 compatible artifact store
+	S3Bucket string `envconfig:"S3_BUCKET" default:""`
+	// Region of the S3 compatible artifact store
+	S3Region string `envconfig:"S3_REGION" default:"us-east-1"`
+	// Credentials of the S3 compatible artifact store
+	S3AccessKeyID     string `envconfig:"S3_ACCESS_KEY_ID" default:""`
+	S3SecretAccessKey string `envconfig:"S3_SECRET_ACCESS_KEY" default:""`
 }
 
+// root of the default Gatling installation and prefix of the temp directories of the test runs
+const (
+	defaultConfDirRoot    = string(os.PathSeparator)
+	defaultTempPathPrefix = ""
+)
+
 // ServiceName specifies the current services name (e.g., used as source when sending CloudEvents)
 const ServiceName = "gatling-service"
 
@@ -55,6 +129,7 @@ func processKeptnCloudEvent(ctx context.Context, event cloudevents.Event) error
 	}
 
 	log.Printf("gotEvent(%s): %s - %s", event.Type(), myKeptn.KeptnContext, event.Context.GetID())
+	recordEventReceived(event.Type(), serviceLabels(myKeptn.Event.GetProject(), myKeptn.Event.GetStage(), myKeptn.Event.GetService()))
 
 	if err != nil {
 		log.Printf("failed to parse incoming cloudevent: %v", err)
@@ -112,13 +187,37 @@ func processKeptnCloudEvent(ctx context.Context, event cloudevents.Event) error
 		parseKeptnCloudEventPayload(event, eventData)
 
 		g := EventHandler{
-			confDirRoot: string(os.PathSeparator),
-			tempPathPrefix: "",
+			confDirRoot: defaultConfDirRoot,
+			tempPathPrefix: defaultTempPathPrefix,
 			executionHandler: ScriptGatlingExecutionHandler,
+			resultStore: resultStore,
+			lastRuns: lastRunMetrics,
+			runner: testRunner,
+			defaultTimeout: defaultTestTimeout,
+			statusInterval: statusUpdateInterval,
+			jvmOptions: defaultJVMOptions,
+			missingResources: missingResourcesPolicy,
+			artifactStore: artifactStore,
+			dedupStore: dedupStore,
 			myKeptn: myKeptn,
 		}
 
 		return g.HandleTestTriggeredEvent(event, eventData)
+
+	// -------------------------------------------------------
+	// sh.keptn.event.get-sli
+	case keptnv2.GetTriggeredEventType(keptnv2.GetSLITaskName): // sh.keptn.event.get-sli.triggered
+		log.Printf("Processing Get-SLI.Triggered Event")
+
+		eventData := &keptnv2.GetSLITriggeredEventData{}
+		parseKeptnCloudEventPayload(event, eventData)
+
+		g := EventHandler{
+			resultStore: resultStore,
+			myKeptn: myKeptn,
+		}
+
+		return g.HandleGetSliTriggeredEvent(event, eventData)
 	}
 
 	// Unknown Event -> Throw Error!
@@ -157,6 +256,31 @@ func _main(args []string, env envConfig) int {
 
 	keptnOptions.ConfigurationServiceURL = env.ConfigurationServiceUrl
 
+	testRunner = NewTestRunner(env.MaxConcurrentTests, env.MaxQueuedTests)
+	defaultTestTimeout = env.DefaultTestTimeout
+	statusUpdateInterval = env.StatusUpdateInterval
+
+	defaultJVMOptions = strings.Fields(env.JVMOptions)
+	if err := validateJVMOptions(defaultJVMOptions); err != nil {
+		log.Fatalf("invalid JVM_OPTIONS, %v", err)
+	}
+
+	missingResourcesPolicy = env.MissingResourcesPolicy
+	if err := validateMissingResourcesPolicy(missingResourcesPolicy); err != nil {
+		log.Fatalf("invalid MISSING_RESOURCES_POLICY, %v", err)
+	}
+
+	var err error
+	artifactStore, err = NewArtifactStore(env)
+	if err != nil {
+		log.Fatalf("failed to create artifact store, %v", err)
+	}
+
+	dedupStore, err = NewDedupStore(env)
+	if err != nil {
+		log.Fatalf("failed to create deduplication store, %v", err)
+	}
+
 	log.Println("Starting gatling-service...")
 	log.Printf("    on Port = %d; Path=%s", env.Port, env.Path)
 
@@ -165,19 +289,57 @@ func _main(args []string, env envConfig) int {
 
 	log.Printf("Creating new http handler")
 
-	// configure http server to receive cloudevents
-	p, err := cloudevents.NewHTTP(cloudevents.WithPath(env.Path), cloudevents.WithPort(env.Port))
-
+	// configure http protocol to receive cloudevents, it's served next to the metrics endpoint
+	p, err := cloudevents.NewHTTP()
 	if err != nil {
 		log.Fatalf("failed to create client, %v", err)
 	}
-	c, err := cloudevents.NewClient(p)
+	receiver, err := cloudevents.NewHTTPReceiveHandler(ctx, p, processKeptnCloudEvent)
 	if err != nil {
-		log.Fatalf("failed to create client, %v", err)
+		log.Fatalf("failed to create handler, %v", err)
 	}
 
-	log.Printf("Starting receiver")
-	log.Fatal(c.StartReceiver(ctx, processKeptnCloudEvent))
+	registerRunnerMetrics(prometheus.DefaultRegisterer, testRunner)
+	prometheus.MustRegister(lastRunMetrics)
+
+	// resources are read from the local filesystem without configuration service
+	configurationServiceURL := env.ConfigurationServiceUrl
+	if keptnOptions.UseLocalFileSystem {
+		configurationServiceURL = ""
+	}
+	checks := readinessChecks(defaultConfDirRoot, defaultTempPathPrefix, configurationServiceURL, testRunner)
+
+	mux := http.NewServeMux()
+	mux.Handle(MetricsPath, promhttp.Handler())
+	mux.HandleFunc(HealthPath, healthHandler)
+	mux.Handle(ReadinessPath, readinessHandler(checks))
+	mux.Handle(env.Path, receiver)
+
+	server := &http.Server{Addr: fmt.Sprintf(":%d", env.Port), Handler: mux}
+	go func() {
+		log.Printf("Starting receiver")
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	<-signals
+
+	// further tests are rejected while the running ones are drained, the receiver keeps answering probes meanwhile
+	log.Printf("Shutting down, waiting up to %s for running tests", env.ShutdownDrainPeriod)
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), env.ShutdownDrainPeriod)
+	defer cancelDrain()
+	if err := testRunner.Shutdown(drainCtx); err != nil {
+		log.Printf("Aborted the running tests: %v", err)
+	}
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelShutdown()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to stop the receiver: %v", err)
+	}
 
 	return 0
 }
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	MetricsNamespace = "gatling_service"
+	MetricsPath      = "/metrics"
+)
+
+var keptnLabels = []string{"project", "stage", "service"}
+
+var (
+	eventsReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "events_received_total",
+		Help:      "Number of received Keptn events by type.",
+	}, labelNames([]string{"type"}, keptnLabels...))
+
+	testRunsFinished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "test_runs_total",
+		Help:      "Number of finished test runs by result and status of the test.finished event.",
+	}, labelNames(keptnLabels, "result", "status"))
+
+	testRunDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: MetricsNamespace,
+		Name:      "test_run_duration_seconds",
+		Help:      "Duration of test runs from the start of the execution until the test.finished event.",
+		Buckets:   []float64{10, 30, 60, 120, 300, 600, 1200, 1800, 3600, 7200},
+	}, keptnLabels)
+
+	resourceDownloadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: MetricsNamespace,
+		Name:      "resource_download_duration_seconds",
+		Help:      "Duration of downloading the Gatling resources from the Keptn configuration repository.",
+		Buckets:   prometheus.DefBuckets,
+	}, keptnLabels)
+
+	gatlingExitCodes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "gatling_exit_codes_total",
+		Help:      "Number of Gatling processes by exit code, \"unknown\" if the process didn't exit regularly.",
+	}, labelNames(keptnLabels, "code"))
+)
+
+// labelNames returns a copy of the names extended by the additional ones
+func labelNames(names []string, additional ...string) []string {
+	return append(append([]string{}, names...), additional...)
+}
+
+// registerRunnerMetrics exposes the number of running and waiting test runs of the runner
+func registerRunnerMetrics(registerer prometheus.Registerer, runner *TestRunner) {
+	registerer.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: MetricsNamespace,
+			Name:      "test_runs_running",
+			Help:      "Number of test runs currently executed.",
+		}, func() float64 {
+			running, _ := runner.Stats()
+			return float64(running)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: MetricsNamespace,
+			Name:      "test_runs_queued",
+			Help:      "Number of test runs waiting for execution.",
+		}, func() float64 {
+			_, queued := runner.Stats()
+			return float64(queued)
+		}),
+	)
+}
+
+// serviceLabels labels of the project, stage and service of an event
+func serviceLabels(project, stage, service string) prometheus.Labels {
+	return prometheus.Labels{"project": project, "stage": stage, "service": service}
+}
+
+// metricLabels labels of the project, stage and service of the handled event
+func (e *EventHandler) metricLabels() prometheus.Labels {
+	return serviceLabels(e.myKeptn.Event.GetProject(), e.myKeptn.Event.GetStage(), e.myKeptn.Event.GetService())
+}
+
+// withLabel returns a copy of the labels extended by the given name and value
+func withLabel(labels prometheus.Labels, name, value string) prometheus.Labels {
+	extended := prometheus.Labels{name: value}
+	for k, v := range labels {
+		extended[k] = v
+	}
+	return extended
+}
+
+// recordEventReceived counts a received event
+func recordEventReceived(eventType string, labels prometheus.Labels) {
+	eventsReceived.With(withLabel(labels, "type", eventType)).Inc()
+}
+
+// recordTestFinished counts a test.finished event
+func recordTestFinished(labels prometheus.Labels, result, status string) {
+	testRunsFinished.With(withLabel(withLabel(labels, "result", result), "status", status)).Inc()
+}
+
+// recordGatlingExit counts the exit code of a Gatling process, err is the result of the execution handler
+func recordGatlingExit(labels prometheus.Labels, err error) {
+	code := "0"
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		code = strconv.Itoa(exitErr.ExitCode())
+	} else if err != nil {
+		code = "unknown"
+	}
+	gatlingExitCodes.With(withLabel(labels, "code", code)).Inc()
+}
+
+// observeSince records the seconds since start
+func observeSince(observer prometheus.ObserverVec, labels prometheus.Labels, start time.Time) {
+	observer.With(labels).Observe(time.Since(start).Seconds())
+}
+
+// LastRunCollector exposes the statistics of the latest run of each simulation per project, stage and service
+type LastRunCollector struct {
+	mutex   sync.RWMutex
+	results map[string]*lastRun
+
+	responseTime *prometheus.Desc
+	mean         *prometheus.Desc
+	requests     *prometheus.Desc
+	throughput   *prometheus.Desc
+	timestamp    *prometheus.Desc
+}
+
+type lastRun struct {
+	labels   []string
+	results  *SimulationResults
+	finished time.Time
+}
+
+// NewLastRunCollector creates a collector without any results
+func NewLastRunCollector() *LastRunCollector {
+	runLabels := labelNames(keptnLabels, "simulation")
+	requestLabels := labelNames(runLabels, "request")
+	return &LastRunCollector{
+		results: map[string]*lastRun{},
+		responseTime: prometheus.NewDesc(prometheus.BuildFQName(MetricsNamespace, "last_run", "response_time_seconds"),
+			"Response time percentiles of the latest run of the simulation.", labelNames(requestLabels, "quantile"), nil),
+		mean: prometheus.NewDesc(prometheus.BuildFQName(MetricsNamespace, "last_run", "response_time_mean_seconds"),
+			"Mean response time of the latest run of the simulation.", requestLabels, nil),
+		requests: prometheus.NewDesc(prometheus.BuildFQName(MetricsNamespace, "last_run", "requests"),
+			"Number of OK and KO requests of the latest run of the simulation.", labelNames(requestLabels, "status"), nil),
+		throughput: prometheus.NewDesc(prometheus.BuildFQName(MetricsNamespace, "last_run", "throughput_requests_per_second"),
+			"Requests per second of the latest run of the simulation.", requestLabels, nil),
+		timestamp: prometheus.NewDesc(prometheus.BuildFQName(MetricsNamespace, "last_run", "timestamp_seconds"),
+			"Time the latest run of the simulation was recorded.", runLabels, nil),
+	}
+}
+
+// Record replaces the statistics of the simulation for the project, stage and service
+func (c *LastRunCollector) Record(project, stage, service, simulation string, results *SimulationResults) {
+	if results == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	labels := []string{project, stage, service, simulation}
+	c.results[strings.Join(labels, "/")] = &lastRun{labels: labels, results: results, finished: time.Now()}
+}
+
+// Describe implements prometheus.Collector
+func (c *LastRunCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.responseTime
+	ch <- c.mean
+	ch <- c.requests
+	ch <- c.throughput
+	ch <- c.timestamp
+}
+
+// Collect implements prometheus.Collector
+func (c *LastRunCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	for _, run := range c.results {
+		ch <- prometheus.MustNewConstMetric(c.timestamp, prometheus.GaugeValue, float64(run.finished.Unix()), run.labels...)
+
+		var requests []*RequestStatistics
+		if run.results.Global != nil {
+			requests = append(requests, run.results.Global)
+		}
+		for _, request := range run.results.Requests {
+			// a request named like the global statistics would duplicate their series and fail the whole scrape
+			if request.Name != GlobalRequestName {
+				requests = append(requests, request)
+			}
+		}
+		for _, request := range requests {
+			labels := labelNames(run.labels, request.Name)
+			quantiles := []struct {
+				quantile string
+				value    int64
+			}{{"0.5", request.P50}, {"0.75", request.P75}, {"0.95", request.P95}, {"0.99", request.P99}}
+			for _, q := range quantiles {
+				ch <- prometheus.MustNewConstMetric(c.responseTime, prometheus.GaugeValue, seconds(float64(q.value)), labelNames(labels, q.quantile)...)
+			}
+			ch <- prometheus.MustNewConstMetric(c.mean, prometheus.GaugeValue, seconds(request.Mean), labels...)
+			ch <- prometheus.MustNewConstMetric(c.requests, prometheus.GaugeValue, float64(request.OK), labelNames(labels, "ok")...)
+			ch <- prometheus.MustNewConstMetric(c.requests, prometheus.GaugeValue, float64(request.KO), labelNames(labels, "ko")...)
+			ch <- prometheus.MustNewConstMetric(c.throughput, prometheus.GaugeValue, request.Throughput, labels...)
+		}
+	}
+}
+
+// seconds converts the milliseconds of the Gatling statistics
+func seconds(milliseconds float64) float64 {
+	return milliseconds / 1000
+}
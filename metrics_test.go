@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRecordGatlingExit(t *testing.T) {
+	exitErr := exec.Command("sh", "-c", "exit 3").Run()
+	if exitErr == nil {
+		t.Fatal("Expected the command to fail")
+	}
+
+	tests := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{"success", nil, "0"},
+		{"exit code", fmt.Errorf("Error executing command gatling.sh: %w", exitErr), "3"},
+		{"no process", errors.New("execution failed"), "unknown"},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			labels := serviceLabels("metrics", "dev", testCase.name)
+			recordGatlingExit(labels, testCase.err)
+			if count := testutil.ToFloat64(gatlingExitCodes.With(withLabel(labels, "code", testCase.expected))); count != 1 {
+				t.Errorf("Expected exit code %s to be counted once got %v", testCase.expected, count)
+			}
+		})
+	}
+}
+
+func TestRunnerMetrics(t *testing.T) {
+	runner := NewTestRunner(1, 5)
+	block := make(chan struct{})
+	for _, id := range []string{"a", "b", "c"} {
+		if err := runner.Submit(&TestRun{ID: id, Project: "metrics", Execute: func() error {
+			<-block
+			return nil
+		}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	registry := prometheus.NewRegistry()
+	registerRunnerMetrics(registry, runner)
+
+	expected := `
+# HELP gatling_service_test_runs_queued Number of test runs waiting for execution.
+# TYPE gatling_service_test_runs_queued gauge
+gatling_service_test_runs_queued 2
+# HELP gatling_service_test_runs_running Number of test runs currently executed.
+# TYPE gatling_service_test_runs_running gauge
+gatling_service_test_runs_running 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	close(block)
+	runner.Wait()
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	recordEventReceived("sh.keptn.event.test.triggered", serviceLabels("metrics", "dev", "endpoint"))
+
+	recorder := httptest.NewRecorder()
+	promhttp.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", MetricsPath, nil))
+	body, _ := ioutil.ReadAll(recorder.Body)
+
+	expected := `gatling_service_events_received_total{project="metrics",service="endpoint",stage="dev",type="sh.keptn.event.test.triggered"} 1`
+	if !strings.Contains(string(body), expected) {
+		t.Errorf("Expected %s in the metrics got %s", expected, string(body))
+	}
+}
+
+func TestLastRunCollector(t *testing.T) {
+	collector := NewLastRunCollector()
+	collector.Record("pod-tato-head", "hardening", "helloservice", "BasicSimulation", &SimulationResults{
+		Global:   &RequestStatistics{Name: GlobalRequestName, Count: 4, OK: 3, KO: 1, Mean: 187.5, P50: 100, P75: 200, P95: 400, P99: 400, Throughput: 2},
+		Requests: []*RequestStatistics{{Name: "request_1", Count: 2, OK: 2, Mean: 75, P50: 50, P75: 100, P95: 100, P99: 100, Throughput: 1}},
+	})
+	// a later run of the simulation replaces the statistics, other simulations are kept
+	collector.Record("pod-tato-head", "hardening", "helloservice", "BasicSimulation", &SimulationResults{
+		Global: &RequestStatistics{Name: GlobalRequestName, Count: 5, OK: 5, Mean: 100, P50: 100, P75: 100, P95: 100, P99: 100, Throughput: 5},
+		// a request named like the global statistics doesn't duplicate their series
+		Requests: []*RequestStatistics{{Name: GlobalRequestName, Count: 1, OK: 1, Mean: 10, P50: 10, P75: 10, P95: 10, P99: 10, Throughput: 1}},
+	})
+	collector.Record("pod-tato-head", "hardening", "helloservice", "SmokeSimulation", &SimulationResults{
+		Global: &RequestStatistics{Name: GlobalRequestName, Count: 1, OK: 1, Mean: 50, P50: 50, P75: 50, P95: 50, P99: 50, Throughput: 1},
+	})
+	collector.Record("pod-tato-head", "hardening", "helloservice", "FailedSimulation", nil)
+
+	expected := `
+# HELP gatling_service_last_run_requests Number of OK and KO requests of the latest run of the simulation.
+# TYPE gatling_service_last_run_requests gauge
+gatling_service_last_run_requests{project="pod-tato-head",request="Global",service="helloservice",simulation="BasicSimulation",stage="hardening",status="ko"} 0
+gatling_service_last_run_requests{project="pod-tato-head",request="Global",service="helloservice",simulation="BasicSimulation",stage="hardening",status="ok"} 5
+gatling_service_last_run_requests{project="pod-tato-head",request="Global",service="helloservice",simulation="SmokeSimulation",stage="hardening",status="ko"} 0
+gatling_service_last_run_requests{project="pod-tato-head",request="Global",service="helloservice",simulation="SmokeSimulation",stage="hardening",status="ok"} 1
+# HELP gatling_service_last_run_response_time_seconds Response time percentiles of the latest run of the simulation.
+# TYPE gatling_service_last_run_response_time_seconds gauge
+gatling_service_last_run_response_time_seconds{project="pod-tato-head",quantile="0.5",request="Global",service="helloservice",simulation="BasicSimulation",stage="hardening"} 0.1
+gatling_service_last_run_response_time_seconds{project="pod-tato-head",quantile="0.5",request="Global",service="helloservice",simulation="SmokeSimulation",stage="hardening"} 0.05
+gatling_service_last_run_response_time_seconds{project="pod-tato-head",quantile="0.75",request="Global",service="helloservice",simulation="BasicSimulation",stage="hardening"} 0.1
+gatling_service_last_run_response_time_seconds{project="pod-tato-head",quantile="0.75",request="Global",service="helloservice",simulation="SmokeSimulation",stage="hardening"} 0.05
+gatling_service_last_run_response_time_seconds{project="pod-tato-head",quantile="0.95",request="Global",service="helloservice",simulation="BasicSimulation",stage="hardening"} 0.1
+gatling_service_last_run_response_time_seconds{project="pod-tato-head",quantile="0.95",request="Global",service="helloservice",simulation="SmokeSimulation",stage="hardening"} 0.05
+gatling_service_last_run_response_time_seconds{project="pod-tato-head",quantile="0.99",request="Global",service="helloservice",simulation="BasicSimulation",stage="hardening"} 0.1
+gatling_service_last_run_response_time_seconds{project="pod-tato-head",quantile="0.99",request="Global",service="helloservice",simulation="SmokeSimulation",stage="hardening"} 0.05
+# HELP gatling_service_last_run_throughput_requests_per_second Requests per second of the latest run of the simulation.
+# TYPE gatling_service_last_run_throughput_requests_per_second gauge
+gatling_service_last_run_throughput_requests_per_second{project="pod-tato-head",request="Global",service="helloservice",simulation="BasicSimulation",stage="hardening"} 5
+gatling_service_last_run_throughput_requests_per_second{project="pod-tato-head",request="Global",service="helloservice",simulation="SmokeSimulation",stage="hardening"} 1
+`
+	err := testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"gatling_service_last_run_requests", "gatling_service_last_run_response_time_seconds", "gatling_service_last_run_throughput_requests_per_second")
+	if err != nil {
+		t.Error(err)
+	}
+	if count := testutil.CollectAndCount(collector, "gatling_service_last_run_timestamp_seconds"); count != 2 {
+		t.Errorf("Expected a timestamp per simulation got %d", count)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	progressElapsedPattern = regexp.MustCompile(`(\S+)\s+elapsed\s*$`)
+	progressGlobalPattern  = regexp.MustCompile(`^> Global\s+\(OK=(\d+)\s+KO=(\d+)\s*\)`)
+	progressUsersPattern   = regexp.MustCompile(`waiting:\s*(\d+)\s*/\s*active:\s*(\d+)\s*/\s*done:\s*(\d+)`)
+)
+
+// TestProgress snapshot of the periodic summary printed by Gatling to the console
+type TestProgress struct {
+	Elapsed      string `json:"elapsed"`
+	Requests     int    `json:"requests"`
+	OK           int    `json:"ok"`
+	KO           int    `json:"ko"`
+	UsersWaiting int    `json:"usersWaiting"`
+	UsersActive  int    `json:"usersActive"`
+	UsersDone    int    `json:"usersDone"`
+}
+
+// progressWriter parses the console output of Gatling and reports each completed summary block
+type progressWriter struct {
+	line    []byte
+	current *TestProgress
+	report  func(progress *TestProgress)
+}
+
+func newProgressWriter(report func(progress *TestProgress)) *progressWriter {
+	return &progressWriter{
+		report: report,
+	}
+}
+
+// Write consumes the console output line by line
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.line = append(w.line, p...)
+	for {
+		index := bytes.IndexByte(w.line, '\n')
+		if index < 0 {
+			break
+		}
+		w.parseLine(strings.TrimRight(string(w.line[:index]), "\r"))
+		w.line = w.line[index+1:]
+	}
+	return len(p), nil
+}
+
+// parseLine tracks the summary blocks which are framed by lines of equal signs
+func (w *progressWriter) parseLine(line string) {
+	if strings.HasPrefix(line, "=====") {
+		if w.current != nil && w.current.Elapsed != "" {
+			w.report(w.current)
+			w.current = nil
+		} else {
+			w.current = &TestProgress{}
+		}
+		return
+	}
+	if w.current == nil {
+		return
+	}
+
+	if match := progressElapsedPattern.FindStringSubmatch(line); match != nil {
+		w.current.Elapsed = match[1]
+	} else if match := progressGlobalPattern.FindStringSubmatch(line); match != nil {
+		w.current.OK, _ = strconv.Atoi(match[1])
+		w.current.KO, _ = strconv.Atoi(match[2])
+		w.current.Requests = w.current.OK + w.current.KO
+	} else if match := progressUsersPattern.FindStringSubmatch(line); match != nil {
+		// summed up over all scenarios
+		waiting, _ := strconv.Atoi(match[1])
+		active, _ := strconv.Atoi(match[2])
+		done, _ := strconv.Atoi(match[3])
+		w.current.UsersWaiting += waiting
+		w.current.UsersActive += active
+		w.current.UsersDone += done
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io/ioutil"
+	"path"
+	"testing"
+)
+
+func TestProgressWriter(t *testing.T) {
+	output, err := ioutil.ReadFile(path.Join("test-data", "console", "gatling.out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reported []*TestProgress
+	writer := newProgressWriter(func(progress *TestProgress) {
+		reported = append(reported, progress)
+	})
+
+	// feed the output in small chunks like a pipe would
+	for i := 0; i < len(output); i += 7 {
+		end := i + 7
+		if end > len(output) {
+			end = len(output)
+		}
+		if _, err = writer.Write(output[i:end]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(reported) != 2 {
+		t.Fatalf("Expected 2 progress reports got %d", len(reported))
+	}
+
+	first := reported[0]
+	if first.Elapsed != "5s" || first.Requests != 10 || first.OK != 10 || first.KO != 0 {
+		t.Errorf("Unexpected first progress %+v", first)
+	}
+	if first.UsersWaiting != 8 || first.UsersActive != 2 || first.UsersDone != 5 {
+		t.Errorf("Unexpected users in first progress %+v", first)
+	}
+
+	second := reported[1]
+	if second.Elapsed != "10s" || second.Requests != 27 || second.KO != 2 {
+		t.Errorf("Unexpected second progress %+v", second)
+	}
+	if second.UsersActive != 4 || second.UsersDone != 12 {
+		t.Errorf("Expected users of all scenarios in second progress %+v", second)
+	}
+}
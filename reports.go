@@ -0,0 +1,137 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+
+	keptnapimodels "github.com/keptn/go-utils/pkg/api/models"
+	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// ReportsResourcePrefix location of uploaded reports, which are excluded when downloading the Gatling resources
+	ReportsResourcePrefix = "gatling/reports/"
+	ReportArchiveName     = "report.zip"
+
+	ReportUploadNone  = "none"
+	ReportUploadZip   = "zip"
+	ReportUploadFiles = "files"
+)
+
+// uploadReports stores the HTML reports of the results folder as resources of the service and returns the resource URI
+func uploadReports(myKeptn *keptnv2.Keptn, conf *GatlingConf, resultsDir string) (string, error) {
+	if conf == nil || conf.Reports == nil || conf.Reports.Upload == "" || conf.Reports.Upload == ReportUploadNone {
+		return "", nil
+	}
+
+	resourceDir := path.Join(ReportsResourcePrefix, myKeptn.KeptnContext)
+
+	var resources []*keptnapimodels.Resource
+	var resourceURI string
+	switch conf.Reports.Upload {
+	case ReportUploadZip:
+		content, err := zipReport(resultsDir)
+		if err != nil {
+			return "", err
+		}
+		resourceURI = path.Join(resourceDir, ReportArchiveName)
+		resources = append(resources, &keptnapimodels.Resource{
+			ResourceURI:     &resourceURI,
+			ResourceContent: string(content),
+		})
+	case ReportUploadFiles:
+		resourceURI = resourceDir + "/"
+		err := walkReportFiles(resultsDir, func(file, relativePath string) error {
+			content, err := ioutil.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			uri := path.Join(resourceDir, relativePath)
+			resources = append(resources, &keptnapimodels.Resource{
+				ResourceURI:     &uri,
+				ResourceContent: string(content),
+			})
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("unknown report upload mode %s", conf.Reports.Upload)
+	}
+
+	if len(resources) == 0 {
+		return "", nil
+	}
+
+	log.Infof("Uploading %d report resource(s) to %s", len(resources), resourceDir)
+	_, err := myKeptn.ResourceHandler.CreateServiceResources(myKeptn.Event.GetProject(), myKeptn.Event.GetStage(), myKeptn.Event.GetService(), resources)
+	if err != nil {
+		return "", err
+	}
+	return resourceURI, nil
+}
+
+// walkFiles calls fn for all files within the directory with their slash separated path relative to the directory
+func walkFiles(dir string, fn func(file, relativePath string) error) error {
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		relativePath, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		return fn(file, filepath.ToSlash(relativePath))
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// walkReportFiles calls fn for the files of the HTML reports within the results folder. The simulation.log is
+// skipped, it's only needed to generate the report and can be hundreds of MB for long running tests.
+func walkReportFiles(resultsDir string, fn func(file, relativePath string) error) error {
+	return walkFiles(resultsDir, func(file, relativePath string) error {
+		if path.Base(relativePath) == SimulationLogName {
+			return nil
+		}
+		return fn(file, relativePath)
+	})
+}
+
+// zipReport creates a zip archive of the HTML reports within the results folder
+func zipReport(resultsDir string) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	archive := zip.NewWriter(buffer)
+
+	err := walkReportFiles(resultsDir, func(file, relativePath string) error {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		writer, err := archive.Create(relativePath)
+		if err != nil {
+			return err
+		}
+		_, err = writer.Write(content)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = archive.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ResultsDirname    = "results"
+	SimulationLogName = "simulation.log"
+	// GlobalRequestName names the statistics of all requests and is reserved for them in metrics, SLIs and assertions
+	GlobalRequestName = "Global"
+)
+
+// SimulationResults aggregated statistics of a Gatling run
+type SimulationResults struct {
+	Simulation string               `json:"simulation"`
+	Start      string               `json:"start"`
+	End        string               `json:"end"`
+	Users      UserStatistics       `json:"users"`
+	Global     *RequestStatistics   `json:"global"`
+	Requests   []*RequestStatistics `json:"requests"`
+	Errors     []*ErrorStatistics   `json:"errors,omitempty"`
+}
+
+// UserStatistics number of virtual users of a Gatling run
+type UserStatistics struct {
+	Started  int `json:"started"`
+	Finished int `json:"finished"`
+}
+
+// RequestStatistics response time statistics in milliseconds and throughput in requests per second
+type RequestStatistics struct {
+	Name       string  `json:"name"`
+	Count      int     `json:"count"`
+	OK         int     `json:"ok"`
+	KO         int     `json:"ko"`
+	Min        int64   `json:"min"`
+	Max        int64   `json:"max"`
+	Mean       float64 `json:"mean"`
+	P50        int64   `json:"p50"`
+	P75        int64   `json:"p75"`
+	P95        int64   `json:"p95"`
+	P99        int64   `json:"p99"`
+	Throughput float64 `json:"throughput"`
+}
+
+// ErrorStatistics number of occurrences of an error message
+type ErrorStatistics struct {
+	Message string `json:"message"`
+	Count   int    `json:"count"`
+}
+
+// simulationLog raw records of a simulation.log file
+type simulationLog struct {
+	simulation    string
+	requests      []requestRecord
+	usersStarted  int
+	usersFinished int
+	errors        map[string]int
+	start         int64
+	end           int64
+}
+
+// requestRecord single REQUEST line of a simulation.log file
+type requestRecord struct {
+	name    string
+	start   int64
+	end     int64
+	ok      bool
+	message string
+}
+
+// parseSimulationLog reads the tab separated records written by Gatling 3.x
+func parseSimulationLog(r io.Reader) (*simulationLog, error) {
+	simLog := &simulationLog{
+		errors: map[string]int{},
+	}
+
+	reader := bufio.NewReader(r)
+	lineNumber := 0
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		lineNumber++
+		if recordErr := simLog.addRecord(strings.TrimRight(line, "\r\n")); recordErr != nil {
+			if err == io.EOF {
+				// the last line is incomplete when the simulation was aborted
+				break
+			}
+			return nil, fmt.Errorf("line %d: %s", lineNumber, recordErr.Error())
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	return simLog, nil
+}
+
+// addRecord parses a single line of a simulation.log file
+func (l *simulationLog) addRecord(line string) error {
+	if line == "" {
+		return nil
+	}
+	fields := strings.Split(line, "\t")
+	switch fields[0] {
+	case "RUN":
+		if len(fields) < 4 {
+			return fmt.Errorf("incomplete RUN record")
+		}
+		l.simulation = fields[1]
+		timestamp, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			return err
+		}
+		l.track(timestamp)
+	case "USER":
+		if len(fields) < 4 {
+			return fmt.Errorf("incomplete USER record")
+		}
+		timestamp, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			return err
+		}
+		l.track(timestamp)
+		switch fields[2] {
+		case "START":
+			l.usersStarted++
+		case "END":
+			l.usersFinished++
+		}
+	case "REQUEST":
+		if len(fields) < 6 {
+			return fmt.Errorf("incomplete REQUEST record")
+		}
+		start, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			return err
+		}
+		end, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return err
+		}
+		record := requestRecord{
+			name:  fields[2],
+			start: start,
+			end:   end,
+			ok:    fields[5] == "OK",
+		}
+		if len(fields) > 6 {
+			record.message = strings.TrimSpace(fields[6])
+		}
+		l.track(start)
+		l.track(end)
+		l.requests = append(l.requests, record)
+	case "ERROR":
+		if len(fields) < 2 {
+			return fmt.Errorf("incomplete ERROR record")
+		}
+		l.errors[fields[1]]++
+	}
+	return nil
+}
+
+// track widens the observed time range of the simulation
+func (l *simulationLog) track(timestamp int64) {
+	if l.start == 0 || timestamp < l.start {
+		l.start = timestamp
+	}
+	if timestamp > l.end {
+		l.end = timestamp
+	}
+}
+
+// computeResults aggregates the records of one or more simulation logs
+func computeResults(logs ...*simulationLog) *SimulationResults {
+	var start, end int64
+	var simulations []string
+	users := UserStatistics{}
+	errors := map[string]int{}
+	byName := map[string][]requestRecord{}
+	var all []requestRecord
+
+	for _, simLog := range logs {
+		if simLog.simulation != "" {
+			simulations = append(simulations, simLog.simulation)
+		}
+		if start == 0 || (simLog.start != 0 && simLog.start < start) {
+			start = simLog.start
+		}
+		if simLog.end > end {
+			end = simLog.end
+		}
+		users.Started += simLog.usersStarted
+		users.Finished += simLog.usersFinished
+		for message, count := range simLog.errors {
+			errors[message] += count
+		}
+		for _, record := range simLog.requests {
+			byName[record.name] = append(byName[record.name], record)
+			if !record.ok && record.message != "" {
+				errors[record.message]++
+			}
+		}
+		all = append(all, simLog.requests...)
+	}
+
+	duration := time.Duration(end-start) * time.Millisecond
+
+	results := &SimulationResults{
+		Simulation: strings.Join(simulations, ","),
+		Start:      time.Unix(0, start*int64(time.Millisecond)).UTC().Format(time.RFC3339),
+		End:        time.Unix(0, end*int64(time.Millisecond)).UTC().Format(time.RFC3339),
+		Users:      users,
+		Global:     computeRequestStatistics(GlobalRequestName, all, duration),
+	}
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == GlobalRequestName {
+			log.Warnf("Request %s is shadowed by the global statistics, it's not available in metrics, SLIs and assertions", name)
+		}
+		results.Requests = append(results.Requests, computeRequestStatistics(name, byName[name], duration))
+	}
+
+	for message, count := range errors {
+		results.Errors = append(results.Errors, &ErrorStatistics{Message: message, Count: count})
+	}
+	sort.Slice(results.Errors, func(i, j int) bool {
+		if results.Errors[i].Count == results.Errors[j].Count {
+			return results.Errors[i].Message < results.Errors[j].Message
+		}
+		return results.Errors[i].Count > results.Errors[j].Count
+	})
+
+	return results
+}
+
+// computeRequestStatistics calculates response time statistics for the given records
+func computeRequestStatistics(name string, records []requestRecord, duration time.Duration) *RequestStatistics {
+	stats := &RequestStatistics{
+		Name:  name,
+		Count: len(records),
+	}
+	if len(records) == 0 {
+		return stats
+	}
+
+	responseTimes := make([]int64, 0, len(records))
+	var sum int64
+	for _, record := range records {
+		if record.ok {
+			stats.OK++
+		} else {
+			stats.KO++
+		}
+		responseTime := record.end - record.start
+		responseTimes = append(responseTimes, responseTime)
+		sum += responseTime
+	}
+	sort.Slice(responseTimes, func(i, j int) bool { return responseTimes[i] < responseTimes[j] })
+
+	stats.Min = responseTimes[0]
+	stats.Max = responseTimes[len(responseTimes)-1]
+	stats.Mean = roundTo(float64(sum)/float64(len(responseTimes)), 2)
+	stats.P50 = percentile(responseTimes, 50)
+	stats.P75 = percentile(responseTimes, 75)
+	stats.P95 = percentile(responseTimes, 95)
+	stats.P99 = percentile(responseTimes, 99)
+
+	if duration > 0 {
+		stats.Throughput = roundTo(float64(stats.Count)/duration.Seconds(), 2)
+	} else {
+		stats.Throughput = float64(stats.Count)
+	}
+	return stats
+}
+
+// percentile returns the nearest-rank percentile of the sorted values
+func percentile(sorted []int64, p float64) int64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func roundTo(value float64, decimals int) float64 {
+	factor := math.Pow(10, float64(decimals))
+	return math.Round(value*factor) / factor
+}
+
+// findSimulationLogs returns all simulation.log files within the given results directory
+func findSimulationLogs(resultsDir string) ([]string, error) {
+	var logs []string
+	err := filepath.Walk(resultsDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && info.Name() == SimulationLogName {
+			logs = append(logs, path)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return logs, err
+}
+
+// loadSimulationResults parses all simulation.log files written into the results folder
+// and returns nil in case no results are available
+func loadSimulationResults(resultsDir string) (*SimulationResults, error) {
+	files, err := findSimulationLogs(resultsDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	var logs []*simulationLog
+	for _, file := range files {
+		simLog, err := readSimulationLog(file)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, simLog)
+	}
+	return computeResults(logs...), nil
+}
+
+func readSimulationLog(file string) (*simulationLog, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	simLog, err := parseSimulationLog(f)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %s", file, err.Error())
+	}
+	return simLog, nil
+}
+
+// metrics of the request statistics by name, used by assertions and SLIs
+var metrics = []struct {
+	name  string
+	value func(s *RequestStatistics) float64
+}{
+	{"requests", func(s *RequestStatistics) float64 { return float64(s.Count) }},
+	{"ok", func(s *RequestStatistics) float64 { return float64(s.OK) }},
+	{"ko", func(s *RequestStatistics) float64 { return float64(s.KO) }},
+	{"error_rate", func(s *RequestStatistics) float64 {
+		if s.Count == 0 {
+			return 0
+		}
+		return roundTo(float64(s.KO)*100/float64(s.Count), 2)
+	}},
+	{"throughput", func(s *RequestStatistics) float64 { return s.Throughput }},
+	{"response_time_min", func(s *RequestStatistics) float64 { return float64(s.Min) }},
+	{"response_time_max", func(s *RequestStatistics) float64 { return float64(s.Max) }},
+	{"response_time_mean", func(s *RequestStatistics) float64 { return s.Mean }},
+	{"response_time_p50", func(s *RequestStatistics) float64 { return float64(s.P50) }},
+	{"response_time_p75", func(s *RequestStatistics) float64 { return float64(s.P75) }},
+	{"response_time_p95", func(s *RequestStatistics) float64 { return float64(s.P95) }},
+	{"response_time_p99", func(s *RequestStatistics) float64 { return float64(s.P99) }},
+}
+
+// Metrics names of the metrics of the request statistics, used by assertions and SLIs
+var Metrics = metricNames()
+
+func metricNames() []string {
+	names := make([]string, 0, len(metrics))
+	for _, metric := range metrics {
+		names = append(names, metric.name)
+	}
+	return names
+}
+
+// metricValue returns the value of a named metric, error rate in percent
+func (s *RequestStatistics) metricValue(metric string) (float64, error) {
+	for _, m := range metrics {
+		if m.name == metric {
+			return m.value(s), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown metric %s", metric)
+}
+
+// requestStatistics returns the statistics of the named request or the global statistics for an empty name. As the
+// name is reserved, GlobalRequestName always refers to the global statistics, even if a request has the same name.
+func (r *SimulationResults) requestStatistics(name string) *RequestStatistics {
+	if name == "" || name == GlobalRequestName {
+		return r.Global
+	}
+	for _, request := range r.Requests {
+		if request.Name == name {
+			return request
+		}
+	}
+	return nil
+}
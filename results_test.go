@@ -0,0 +1,133 @@
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestParseSimulationLog(t *testing.T) {
+	f, err := os.Open(path.Join("test-data", "results", SimulationLogName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	simLog, err := parseSimulationLog(f)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	results := computeResults(simLog)
+
+	if results.Simulation != "computerdatabase.BasicSimulation" {
+		t.Errorf("Expected simulation computerdatabase.BasicSimulation got %s", results.Simulation)
+	}
+	if results.Users.Started != 1 || results.Users.Finished != 1 {
+		t.Errorf("Expected 1 started and finished user got %d/%d", results.Users.Started, results.Users.Finished)
+	}
+
+	global := results.Global
+	if global.Count != 4 || global.OK != 3 || global.KO != 1 {
+		t.Errorf("Unexpected global counts %d/%d/%d", global.Count, global.OK, global.KO)
+	}
+	if global.Min != 50 || global.Max != 400 || global.Mean != 187.5 {
+		t.Errorf("Unexpected global min/max/mean %d/%d/%f", global.Min, global.Max, global.Mean)
+	}
+	if global.P50 != 100 || global.P75 != 200 || global.P95 != 400 || global.P99 != 400 {
+		t.Errorf("Unexpected global percentiles %d/%d/%d/%d", global.P50, global.P75, global.P95, global.P99)
+	}
+	if global.Throughput != 2 {
+		t.Errorf("Expected throughput of 2 got %f", global.Throughput)
+	}
+
+	if len(results.Requests) != 2 || results.Requests[0].Name != "request_1" || results.Requests[1].Name != "request_2" {
+		t.Fatalf("Unexpected requests %v", results.Requests)
+	}
+	if results.Requests[1].KO != 1 || results.Requests[1].Max != 400 {
+		t.Errorf("Unexpected statistics for request_2 %+v", results.Requests[1])
+	}
+
+	if len(results.Errors) != 1 || results.Errors[0].Count != 1 {
+		t.Errorf("Expected a single error got %v", results.Errors)
+	}
+}
+
+func TestParseSimulationLogInvalid(t *testing.T) {
+	_, err := parseSimulationLog(strings.NewReader("REQUEST\t\trequest_1\tnot-a-number\t1623411018300\tOK\t \n"))
+	if err == nil || !strings.HasPrefix(err.Error(), "line 1:") {
+		t.Errorf("Expected a line related error got %v", err)
+	}
+}
+
+func TestLoadSimulationResultsWithoutResults(t *testing.T) {
+	results, err := loadSimulationResults(path.Join("test-data", "simple", ResultsDirname))
+	if err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+	if results != nil {
+		t.Errorf("Expected no results got %v", results)
+	}
+}
+
+func TestParseSimulationLogTruncated(t *testing.T) {
+	input := "REQUEST\t\trequest_1\t1623411018200\t1623411018300\tOK\t \nREQUEST\t\trequest_1\t16234110"
+	simLog, err := parseSimulationLog(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if len(simLog.requests) != 1 {
+		t.Errorf("Expected the complete record only got %d", len(simLog.requests))
+	}
+}
+
+func TestMetricValue(t *testing.T) {
+	statistics := &RequestStatistics{Count: 3, OK: 2, KO: 1, Throughput: 1.5, Min: 10, Mean: 20.5, P95: 40}
+
+	tests := []struct {
+		metric   string
+		expected float64
+	}{
+		{"requests", 3},
+		{"ko", 1},
+		{"error_rate", 33.33},
+		{"throughput", 1.5},
+		{"response_time_min", 10},
+		{"response_time_mean", 20.5},
+		{"response_time_p95", 40},
+	}
+	for _, testCase := range tests {
+		if value, err := statistics.metricValue(testCase.metric); err != nil || value != testCase.expected {
+			t.Errorf("Expected %v for %s got %v/%v", testCase.expected, testCase.metric, value, err)
+		}
+	}
+
+	if value, err := (&RequestStatistics{}).metricValue("error_rate"); err != nil || value != 0 {
+		t.Errorf("Expected no error rate without requests got %v/%v", value, err)
+	}
+	if _, err := statistics.metricValue("p95"); err == nil {
+		t.Errorf("Expected an error for an unknown metric")
+	}
+}
+
+func TestRequestStatisticsGlobalName(t *testing.T) {
+	results := &SimulationResults{
+		Global:   &RequestStatistics{Name: GlobalRequestName, Count: 5},
+		Requests: []*RequestStatistics{{Name: GlobalRequestName, Count: 1}, {Name: "request_1", Count: 4}},
+	}
+
+	tests := []struct {
+		name     string
+		expected int
+	}{
+		{"", 5},
+		{GlobalRequestName, 5},
+		{"request_1", 4},
+	}
+	for _, testCase := range tests {
+		if stats := results.requestStatistics(testCase.name); stats == nil || stats.Count != testCase.expected {
+			t.Errorf("Expected %d requests for %q got %+v", testCase.expected, testCase.name, stats)
+		}
+	}
+}
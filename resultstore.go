@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"sync"
+)
+
+// DefaultResultStoreSize number of test runs kept in memory
+const DefaultResultStoreSize = 100
+
+// ResultStore keeps the results of finished test runs to answer get-sli requests
+type ResultStore struct {
+	mutex   sync.RWMutex
+	size    int
+	keys    []string
+	results map[string]*SimulationResults
+}
+
+// NewResultStore creates a store which keeps the results of the latest test runs
+func NewResultStore(size int) *ResultStore {
+	return &ResultStore{
+		size:    size,
+		results: map[string]*SimulationResults{},
+	}
+}
+
+func resultKey(keptnContext, project, stage, service string) string {
+	return strings.Join([]string{keptnContext, project, stage, service}, "/")
+}
+
+// Put stores the results of a test run and drops the oldest runs when the store is full
+func (s *ResultStore) Put(keptnContext, project, stage, service string, results *SimulationResults) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := resultKey(keptnContext, project, stage, service)
+	if _, ok := s.results[key]; !ok {
+		s.keys = append(s.keys, key)
+	}
+	s.results[key] = results
+
+	for len(s.keys) > s.size {
+		delete(s.results, s.keys[0])
+		s.keys = s.keys[1:]
+	}
+}
+
+// Get returns the results of the matching test run or nil
+func (s *ResultStore) Get(keptnContext, project, stage, service string) *SimulationResults {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.results[resultKey(keptnContext, project, stage, service)]
+}
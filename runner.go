@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TestRun a test execution scheduled by the TestRunner
+type TestRun struct {
+	ID      string
+	Project string
+	Execute func() error
+	// Cancel is called instead of Execute when the run is taken out of the queue on shutdown
+	Cancel func() error
+}
+
+// TestRunner executes test runs in the background, decoupled from the receiving of events.
+// The number of parallel runs is limited, waiting runs are queued per project and started round robin
+// so a single project can't block the others.
+type TestRunner struct {
+	mutex       sync.Mutex
+	wg          sync.WaitGroup
+	concurrency int
+	maxQueued   int
+	running     int
+	queued      int
+	closed      bool
+	projects    []string
+	queues      map[string][]*TestRun
+	ctx         context.Context
+	abort       context.CancelFunc
+}
+
+// NewTestRunner creates a runner executing at most concurrency test runs in parallel and keeping at most maxQueued waiting
+func NewTestRunner(concurrency, maxQueued int) *TestRunner {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	ctx, abort := context.WithCancel(context.Background())
+	return &TestRunner{
+		concurrency: concurrency,
+		maxQueued:   maxQueued,
+		queues:      map[string][]*TestRun{},
+		ctx:         ctx,
+		abort:       abort,
+	}
+}
+
+// Submit schedules the test run and returns immediately, the run is rejected in case the queue is full
+func (r *TestRunner) Submit(run *TestRun) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed {
+		return fmt.Errorf("service is shutting down, rejecting test run %s", run.ID)
+	}
+	if r.running >= r.concurrency && r.queued >= r.maxQueued {
+		return fmt.Errorf("test queue is full (%d test runs running, %d waiting), rejecting test run %s", r.running, r.queued, run.ID)
+	}
+
+	if _, ok := r.queues[run.Project]; !ok {
+		r.projects = append(r.projects, run.Project)
+	}
+	r.queues[run.Project] = append(r.queues[run.Project], run)
+	r.queued++
+	r.wg.Add(1)
+
+	if r.running >= r.concurrency {
+		log.Infof("Queued test run %s for project %s (%d waiting)", run.ID, run.Project, r.queued)
+	}
+	r.dispatch()
+	return nil
+}
+
+// Wait blocks until all submitted test runs are finished
+func (r *TestRunner) Wait() {
+	r.wg.Wait()
+}
+
+// Closed returns whether the runner rejects further test runs due to the shutdown of the service
+func (r *TestRunner) Closed() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.closed
+}
+
+// Context is canceled when the runner aborts the test runs on shutdown
+func (r *TestRunner) Context() context.Context {
+	return r.ctx
+}
+
+// Shutdown rejects further test runs, cancels the queued ones and waits for the running ones to finish. When ctx
+// is done before, the test runs are aborted through the context of the runner and ctx.Err() is returned once they've finished.
+func (r *TestRunner) Shutdown(ctx context.Context) error {
+	r.mutex.Lock()
+	r.closed = true
+	var canceled []*TestRun
+	for r.queued > 0 {
+		canceled = append(canceled, r.next())
+		r.queued--
+	}
+	r.mutex.Unlock()
+
+	for _, run := range canceled {
+		r.cancel(run)
+		r.wg.Done()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		r.abort()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// Stats returns the number of running and waiting test runs
+func (r *TestRunner) Stats() (running int, queued int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.running, r.queued
+}
+
+// Saturated returns whether further test runs would be rejected
+func (r *TestRunner) Saturated() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.running >= r.concurrency && r.queued >= r.maxQueued
+}
+
+// dispatch starts waiting runs while workers are available, the caller must hold the lock
+func (r *TestRunner) dispatch() {
+	for r.running < r.concurrency && r.queued > 0 {
+		run := r.next()
+		r.running++
+		r.queued--
+
+		go func() {
+			defer r.wg.Done()
+			r.execute(run)
+
+			r.mutex.Lock()
+			r.running--
+			r.dispatch()
+			r.mutex.Unlock()
+		}()
+	}
+}
+
+// next takes the oldest run of the next project in turn, the caller must hold the lock
+func (r *TestRunner) next() *TestRun {
+	project := r.projects[0]
+	r.projects = r.projects[1:]
+
+	queue := r.queues[project]
+	run := queue[0]
+	if len(queue) > 1 {
+		r.queues[project] = queue[1:]
+		r.projects = append(r.projects, project)
+	} else {
+		delete(r.queues, project)
+	}
+	return run
+}
+
+func (r *TestRunner) execute(run *TestRun) {
+	log.Infof("Executing test run %s for project %s", run.ID, run.Project)
+	if err := run.Execute(); err != nil {
+		log.Errorf("Test run %s failed: %s", run.ID, err.Error())
+	}
+}
+
+func (r *TestRunner) cancel(run *TestRun) {
+	log.Infof("Canceling queued test run %s for project %s", run.ID, run.Project)
+	if run.Cancel == nil {
+		return
+	}
+	if err := run.Cancel(); err != nil {
+		log.Errorf("Canceling test run %s failed: %s", run.ID, err.Error())
+	}
+}
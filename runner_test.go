@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTestRunner(t *testing.T) {
+	t.Run("Limits concurrent runs", func(t *testing.T) {
+		runner := NewTestRunner(2, 10)
+
+		var mutex sync.Mutex
+		running, maxRunning := 0, 0
+		started := make(chan struct{}, 5)
+		release := make(chan struct{})
+		for i := 0; i < 5; i++ {
+			err := runner.Submit(&TestRun{
+				ID:      "run",
+				Project: "project",
+				Execute: func() error {
+					mutex.Lock()
+					running++
+					if running > maxRunning {
+						maxRunning = running
+					}
+					mutex.Unlock()
+					started <- struct{}{}
+					<-release
+					mutex.Lock()
+					running--
+					mutex.Unlock()
+					return nil
+				},
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+		}
+
+		<-started
+		<-started
+		if running, queued := runner.Stats(); running != 2 || queued != 3 {
+			t.Errorf("Expected 2 running and 3 queued runs got %d/%d", running, queued)
+		}
+
+		close(release)
+		runner.Wait()
+
+		if maxRunning != 2 {
+			t.Errorf("Expected at most 2 parallel runs got %d", maxRunning)
+		}
+	})
+
+	t.Run("Rejects runs when the queue is full", func(t *testing.T) {
+		runner := NewTestRunner(1, 1)
+		release := make(chan struct{})
+		blocking := func() error {
+			<-release
+			return nil
+		}
+
+		if err := runner.Submit(&TestRun{ID: "first", Project: "a", Execute: blocking}); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		if err := runner.Submit(&TestRun{ID: "second", Project: "a", Execute: blocking}); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		if err := runner.Submit(&TestRun{ID: "third", Project: "a", Execute: blocking}); err == nil {
+			t.Errorf("Expected the queue to be full")
+		}
+		if running, queued := runner.Stats(); running != 1 || queued != 1 {
+			t.Errorf("Expected 1 running and 1 queued run got %d/%d", running, queued)
+		}
+
+		close(release)
+		runner.Wait()
+	})
+
+	t.Run("Starts waiting runs round robin per project", func(t *testing.T) {
+		runner := NewTestRunner(1, 10)
+		release := make(chan struct{})
+		var order []string
+		record := func(id string) func() error {
+			return func() error {
+				order = append(order, id)
+				return nil
+			}
+		}
+
+		_ = runner.Submit(&TestRun{ID: "blocking", Project: "a", Execute: func() error {
+			<-release
+			return nil
+		}})
+		_ = runner.Submit(&TestRun{ID: "a1", Project: "a", Execute: record("a1")})
+		_ = runner.Submit(&TestRun{ID: "a2", Project: "a", Execute: record("a2")})
+		_ = runner.Submit(&TestRun{ID: "a3", Project: "a", Execute: record("a3")})
+		_ = runner.Submit(&TestRun{ID: "b1", Project: "b", Execute: record("b1")})
+		_ = runner.Submit(&TestRun{ID: "c1", Project: "c", Execute: record("c1")})
+
+		close(release)
+		runner.Wait()
+
+		expected := []string{"a1", "b1", "c1", "a2", "a3"}
+		if len(order) != len(expected) {
+			t.Fatalf("Expected %v got %v", expected, order)
+		}
+		for i := range expected {
+			if order[i] != expected[i] {
+				t.Fatalf("Expected %v got %v", expected, order)
+			}
+		}
+	})
+
+	t.Run("Drains the runs on shutdown", func(t *testing.T) {
+		runner := NewTestRunner(1, 10)
+		release := make(chan struct{})
+		var finished, canceled []string
+		for _, id := range []string{"running", "queued"} {
+			id := id
+			_ = runner.Submit(&TestRun{ID: id, Project: "a", Execute: func() error {
+				<-release
+				finished = append(finished, id)
+				return nil
+			}, Cancel: func() error {
+				canceled = append(canceled, id)
+				close(release)
+				return nil
+			}})
+		}
+
+		if err := runner.Shutdown(context.Background()); err != nil {
+			t.Errorf("Unexpected error: %s", err.Error())
+		}
+		if len(finished) != 1 || finished[0] != "running" || runner.Context().Err() != nil {
+			t.Errorf("Expected the running run to finish without being aborted got %v", finished)
+		}
+		if len(canceled) != 1 || canceled[0] != "queued" {
+			t.Errorf("Expected the queued run to be canceled got %v", canceled)
+		}
+		if err := runner.Submit(&TestRun{ID: "late", Project: "a", Execute: func() error { return nil }}); err == nil {
+			t.Errorf("Expected runs to be rejected after the shutdown")
+		}
+	})
+
+	t.Run("Aborts the runs when the drain period is over", func(t *testing.T) {
+		runner := NewTestRunner(1, 10)
+		var aborted []string
+		for _, id := range []string{"running", "queued"} {
+			id := id
+			_ = runner.Submit(&TestRun{ID: id, Project: "a", Execute: func() error {
+				<-runner.Context().Done()
+				aborted = append(aborted, id)
+				return nil
+			}, Cancel: func() error { return nil }})
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := runner.Shutdown(ctx); err != context.DeadlineExceeded {
+			t.Errorf("Expected the deadline to be exceeded got %v", err)
+		}
+		if len(aborted) != 1 || aborted[0] != "running" {
+			t.Errorf("Expected only the running run to be aborted got %v", aborted)
+		}
+	})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
+	log "github.com/sirupsen/logrus"
+)
+
+// SLIProviderName name of the SLI provider handled by this service
+const SLIProviderName = "gatling"
+
+// HandleGetSliTriggeredEvent handles get-sli.triggered events by looking up the results of the test run of the same Keptn context
+func (e *EventHandler) HandleGetSliTriggeredEvent(incomingEvent cloudevents.Event, data *keptnv2.GetSLITriggeredEventData) error {
+	if data.GetSLI.SLIProvider != SLIProviderName {
+		log.Infof("Ignoring get-sli.triggered Event %s for SLI provider %s", incomingEvent.Context.GetID(), data.GetSLI.SLIProvider)
+		return nil
+	}
+	log.Infof("Handling get-sli.triggered Event: %s", incomingEvent.Context.GetID())
+
+	_, err := e.myKeptn.SendTaskStartedEvent(&keptnv2.EventData{}, ServiceName)
+	if err != nil {
+		log.Errorf("Failed to send task started CloudEvent (%s), aborting... \n", err.Error())
+		return err
+	}
+
+	project, stage, service := e.myKeptn.Event.GetProject(), e.myKeptn.Event.GetStage(), e.myKeptn.Event.GetService()
+
+	var results *SimulationResults
+	if e.resultStore != nil {
+		results = e.resultStore.Get(e.myKeptn.KeptnContext, project, stage, service)
+	}
+	if results == nil {
+		err = fmt.Errorf("no Gatling results found for %s.%s.%s in context %s", project, stage, service, e.myKeptn.KeptnContext)
+		return e.erroredGetSliFinishedEvent(data, err)
+	}
+
+	indicatorValues := getIndicatorValues(data.GetSLI.Indicators, results)
+
+	result := keptnv2.ResultPass
+	message := ""
+	var failed []string
+	for _, value := range indicatorValues {
+		if !value.Success {
+			failed = append(failed, value.Message)
+		}
+	}
+	if len(failed) > 0 {
+		result = keptnv2.ResultFailed
+		message = strings.Join(failed, "; ")
+	}
+
+	_, err = e.myKeptn.SendTaskFinishedEvent(&keptnv2.GetSLIFinishedEventData{
+		EventData: keptnv2.EventData{
+			Status:  keptnv2.StatusSucceeded,
+			Result:  result,
+			Message: message,
+		},
+		GetSLI: keptnv2.GetSLIFinished{
+			Start:           data.GetSLI.Start,
+			End:             data.GetSLI.End,
+			IndicatorValues: indicatorValues,
+		},
+	}, ServiceName)
+	return err
+}
+
+// getIndicatorValues maps indicator names either as "<metric>" or "<metric>:<request name>" to the result statistics
+func getIndicatorValues(indicators []string, results *SimulationResults) []*keptnv2.SLIResult {
+	values := make([]*keptnv2.SLIResult, 0, len(indicators))
+	for _, indicator := range indicators {
+		metric, request := indicator, ""
+		if index := strings.Index(indicator, ":"); index >= 0 {
+			metric, request = indicator[:index], indicator[index+1:]
+		}
+
+		sliResult := &keptnv2.SLIResult{
+			Metric: indicator,
+		}
+		values = append(values, sliResult)
+
+		stats := results.requestStatistics(request)
+		if stats == nil {
+			sliResult.Message = fmt.Sprintf("request %s not found for indicator %s", request, indicator)
+			continue
+		}
+		value, err := stats.metricValue(metric)
+		if err != nil {
+			sliResult.Message = fmt.Sprintf("%s for indicator %s", err.Error(), indicator)
+			continue
+		}
+		sliResult.Value = value
+		sliResult.Success = true
+	}
+	return values
+}
+
+func (e *EventHandler) erroredGetSliFinishedEvent(data *keptnv2.GetSLITriggeredEventData, err error) error {
+	log.Error(err)
+	_, eventErr := e.myKeptn.SendTaskFinishedEvent(&keptnv2.GetSLIFinishedEventData{
+		EventData: keptnv2.EventData{
+			Status:  keptnv2.StatusErrored,
+			Result:  keptnv2.ResultFailed,
+			Message: err.Error(),
+		},
+		GetSLI: keptnv2.GetSLIFinished{
+			Start: data.GetSLI.Start,
+			End:   data.GetSLI.End,
+		},
+	}, ServiceName)
+	if eventErr != nil {
+		log.Errorf("Error sending get-sli finished event: %s", eventErr.Error())
+	}
+	return err
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"testing"
+
+	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
+	"github.com/keptn/go-utils/pkg/lib/v0_2_0/fake"
+)
+
+func TestHandleGetSliTriggeredEvent(t *testing.T) {
+	type test struct {
+		name           string
+		storeResults   bool
+		expectedStatus keptnv2.StatusType
+		expectedValues map[string]float64
+	}
+
+	tests := []test{
+		{
+			"Indicator values of the stored test run",
+			true,
+			keptnv2.StatusSucceeded,
+			map[string]float64{
+				"response_time_p95":            400,
+				"error_rate":                   25,
+				"throughput":                   2,
+				"response_time_mean:request_1": 150,
+			},
+		},
+		{
+			"Errored without stored test run",
+			false,
+			keptnv2.StatusErrored,
+			map[string]float64{},
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			myKeptn, incomingEvent, err := initializeTestObjects("", "test-events/get-sli.triggered.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			specificEvent := &keptnv2.GetSLITriggeredEventData{}
+			if err = incomingEvent.DataAs(specificEvent); err != nil {
+				t.Fatal(err)
+			}
+
+			store := NewResultStore(DefaultResultStoreSize)
+			if testCase.storeResults {
+				store.Put(myKeptn.KeptnContext, "pod-tato-head", "hardening", "helloservice", loadTestResults(t))
+			}
+
+			g := EventHandler{
+				resultStore: store,
+				myKeptn:     myKeptn,
+			}
+			_ = g.HandleGetSliTriggeredEvent(*incomingEvent, specificEvent)
+
+			eventSender := myKeptn.EventSender.(*fake.EventSender)
+			err = eventSender.AssertSentEventTypes([]string{
+				keptnv2.GetStartedEventType(keptnv2.GetSLITaskName),
+				keptnv2.GetFinishedEventType(keptnv2.GetSLITaskName),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sentEvent := &keptnv2.GetSLIFinishedEventData{}
+			if err = eventSender.SentEvents[1].DataAs(sentEvent); err != nil {
+				t.Fatalf("Error getting keptn event data")
+			}
+			if sentEvent.Status != testCase.expectedStatus {
+				t.Errorf("Expected status %s got %s", testCase.expectedStatus, sentEvent.Status)
+			}
+			if len(sentEvent.GetSLI.IndicatorValues) != len(testCase.expectedValues) {
+				t.Fatalf("Expected %d indicator values got %d", len(testCase.expectedValues), len(sentEvent.GetSLI.IndicatorValues))
+			}
+			for _, value := range sentEvent.GetSLI.IndicatorValues {
+				if !value.Success || value.Value != testCase.expectedValues[value.Metric] {
+					t.Errorf("Unexpected value %f for %s", value.Value, value.Metric)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleGetSliTriggeredEventOtherProvider(t *testing.T) {
+	myKeptn, incomingEvent, err := initializeTestObjects("", "test-events/get-sli.triggered.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	specificEvent := &keptnv2.GetSLITriggeredEventData{}
+	if err = incomingEvent.DataAs(specificEvent); err != nil {
+		t.Fatal(err)
+	}
+	specificEvent.GetSLI.SLIProvider = "prometheus"
+
+	g := EventHandler{
+		resultStore: NewResultStore(DefaultResultStoreSize),
+		myKeptn:     myKeptn,
+	}
+	if err = g.HandleGetSliTriggeredEvent(*incomingEvent, specificEvent); err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+	if gotEvents := len(myKeptn.EventSender.(*fake.EventSender).SentEvents); gotEvents != 0 {
+		t.Errorf("Expected no events got %d", gotEvents)
+	}
+}
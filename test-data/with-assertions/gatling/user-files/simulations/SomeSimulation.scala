@@ -0,0 +1 @@
+SomeSimulation
\ No newline at end of file